@@ -0,0 +1,127 @@
+package manners
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// An AbortedRequest describes a request that was still being handled when
+// the ShutdownPolicy gave up on draining, or that was answered with 503
+// Service Unavailable when the drain budget ran out while its handler kept
+// running, so whether its work was done is unknown. Operators can use these to reconcile non-idempotent operations.
+type AbortedRequest struct {
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	RequestID  string    `json:"request_id,omitempty"`
+	RemoteAddr string    `json:"remote_addr"`
+	Started    time.Time `json:"started"`
+	Aborted    time.Time `json:"aborted"`
+}
+
+// abortedContextKey is the request context key under which trackRequest
+// stores the AbortedRequest describing the request.
+const abortedContextKey contextKey = 3
+
+// trackRequest records r as in flight until the returned function is
+// called, so that it can be reported if it is aborted. Requests are only
+// tracked when the ShutdownPolicy can give up on draining.
+func (s *GracefulServer) trackRequest(r *http.Request) (*http.Request, func()) {
+	if s.ShutdownPolicy.DrainTimeout <= 0 && s.ShutdownPolicy.HardTimeout <= 0 {
+		return r, func() {}
+	}
+	header := s.RequestIDHeader
+	if header == "" {
+		header = "X-Request-Id"
+	}
+	ar := &AbortedRequest{
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		RequestID:  r.Header.Get(header),
+		RemoteAddr: r.RemoteAddr,
+		Started:    time.Now(),
+	}
+
+	s.requestsmu.Lock()
+	if s.requests == nil {
+		s.requests = make(map[*AbortedRequest]struct{})
+	}
+	s.requests[ar] = struct{}{}
+	s.requestsmu.Unlock()
+
+	r = r.WithContext(context.WithValue(r.Context(), abortedContextKey, ar))
+	return r, func() {
+		s.requestsmu.Lock()
+		delete(s.requests, ar)
+		s.requestsmu.Unlock()
+	}
+}
+
+// abortRequest records r as aborted now, rather than when the server starts
+// closing connections forcibly, because the drain budget cut it off.
+func (s *GracefulServer) abortRequest(r *http.Request) {
+	ar, ok := r.Context().Value(abortedContextKey).(*AbortedRequest)
+	if !ok {
+		return
+	}
+	s.requestsmu.Lock()
+	_, running := s.requests[ar]
+	delete(s.requests, ar)
+	s.requestsmu.Unlock()
+	if !running {
+		return
+	}
+
+	aborted := *ar
+	aborted.Aborted = time.Now()
+	s.lcsmu.Lock()
+	s.report.Aborted = append(s.report.Aborted, aborted)
+	s.lcsmu.Unlock()
+}
+
+// recordAborted adds the requests in flight to the shutdown report.
+func (s *GracefulServer) recordAborted() {
+	now := time.Now()
+	s.requestsmu.Lock()
+	aborted := make([]AbortedRequest, 0, len(s.requests))
+	for ar := range s.requests {
+		a := *ar
+		a.Aborted = now
+		aborted = append(aborted, a)
+	}
+	s.requestsmu.Unlock()
+
+	s.lcsmu.Lock()
+	s.report.Aborted = append(s.report.Aborted, aborted...)
+	s.lcsmu.Unlock()
+}
+
+// persistAborted appends the aborted requests to AbortedRequestsFile, one
+// JSON object per line.
+func (s *GracefulServer) persistAborted(aborted []AbortedRequest) error {
+	if s.AbortedRequestsFile == "" || len(aborted) == 0 {
+		return nil
+	}
+	if err := appendJSONLines(s.AbortedRequestsFile, aborted); err != nil {
+		return fmt.Errorf("manners: persisting aborted requests: %w", err)
+	}
+	return nil
+}
+
+func appendJSONLines(name string, aborted []AbortedRequest) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, ar := range aborted {
+		if err := enc.Encode(ar); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
@@ -0,0 +1,82 @@
+package manners
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Tests that the requests still running at the forced close are listed in
+// the shutdown report and appended to the aborted requests file.
+func TestAbortedRequests(t *testing.T) {
+	server := NewServer()
+	server.ShutdownPolicy.DrainTimeout = 20 * time.Millisecond
+	server.AbortedRequestsFile = filepath.Join(t.TempDir(), "aborted.json")
+	addr, exitchan := startServer(t, server, nil)
+
+	server.SwapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+
+	go func() {
+		req, _ := http.NewRequest("POST", "http://"+addr.String()+"/pay", nil)
+		req.Header.Set("X-Request-Id", "abc123")
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	for i := 0; server.Stats().InFlight < 1; i++ {
+		if i == 100 {
+			t.Fatal("request did not start")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	server.Close()
+	<-exitchan
+
+	aborted := server.ShutdownReport().Aborted
+	if len(aborted) != 1 {
+		t.Fatalf("Expected 1 aborted request, got %+v", aborted)
+	}
+	if a := aborted[0]; a.Method != "POST" || a.Path != "/pay" || a.RequestID != "abc123" || a.Aborted.Before(a.Started) {
+		t.Errorf("Unexpected aborted request %+v", a)
+	}
+
+	f, err := os.Open(server.AbortedRequestsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var persisted []AbortedRequest
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var a AbortedRequest
+		if err := json.Unmarshal(sc.Bytes(), &a); err != nil {
+			t.Fatal(err)
+		}
+		persisted = append(persisted, a)
+	}
+	if len(persisted) != 1 || persisted[0].RequestID != "abc123" {
+		t.Errorf("Expected the aborted request to be persisted, got %+v", persisted)
+	}
+}
+
+// Tests that requests are not tracked when the ShutdownPolicy never gives
+// up on draining.
+func TestAbortedRequestsUntracked(t *testing.T) {
+	server := NewServer()
+	r, _ := http.NewRequest("GET", "/", nil)
+	_, done := server.trackRequest(r)
+	server.requestsmu.Lock()
+	n := len(server.requests)
+	server.requestsmu.Unlock()
+	done()
+	if n != 0 {
+		t.Errorf("Expected no tracked requests without timeouts, got %d", n)
+	}
+}
@@ -0,0 +1,77 @@
+package manners
+
+import "time"
+
+// DrainProgress reports how far a shutting down server has got with
+// draining.
+type DrainProgress struct {
+	// Connections is the number of connections still open.
+	Connections int
+
+	// InFlight is the number of requests still being handled.
+	InFlight int64
+
+	// Routines is the number of routines the server is still waiting for,
+	// which includes active connections.
+	Routines int
+
+	// Elapsed is how long the server has been shutting down.
+	Elapsed time.Duration
+}
+
+// Draining returns a channel on which the server reports its progress every
+// interval once it starts shutting down, starting with a report as soon as
+// Close is called. The channel is closed when the server has finished
+// shutting down. If the receiver falls behind, a report it has not read yet
+// is replaced by the latest one rather than delaying it. Draining panics if
+// interval is not positive, like time.NewTicker.
+func (s *GracefulServer) Draining(interval time.Duration) <-chan DrainProgress {
+	if interval <= 0 {
+		panic("manners: non-positive interval for Draining")
+	}
+	s.lcsmu.RLock()
+	closing, done := s.closing, s.done
+	s.lcsmu.RUnlock()
+
+	progress := make(chan DrainProgress, 1)
+	go func() {
+		defer close(progress)
+		select {
+		case <-closing:
+		case <-done:
+			return
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			p := s.drainProgress()
+			// Drop the stale report, if any. This goroutine is the only
+			// sender, so the send then never blocks.
+			select {
+			case <-progress:
+			default:
+			}
+			progress <- p
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return progress
+}
+
+func (s *GracefulServer) drainProgress() DrainProgress {
+	st := s.Stats()
+	p := DrainProgress{
+		InFlight: st.InFlight,
+		Routines: st.Routines,
+		Elapsed:  st.ShutdownDuration,
+	}
+	for _, n := range st.Connections {
+		p.Connections += n
+	}
+	return p
+}
@@ -0,0 +1,69 @@
+package manners
+
+import (
+	"testing"
+	"time"
+)
+
+// Tests that Draining reports the routines a shutdown is waiting for until
+// the shutdown finishes.
+func TestDraining(t *testing.T) {
+	server := NewServer()
+	_, exitchan := startServer(t, server, nil)
+
+	progress := server.Draining(10 * time.Millisecond)
+	server.StartRoutine()
+	server.Close()
+
+	p, ok := <-progress
+	if !ok {
+		t.Fatal("progress channel closed before the drain finished")
+	}
+	if p.Routines != 1 {
+		t.Errorf("expected 1 outstanding routine, got %+v", p)
+	}
+
+	server.FinishRoutine()
+	for range progress {
+	}
+	if err := <-exitchan; err != nil {
+		t.Error("Unexpected error during shutdown", err)
+	}
+}
+
+// Tests that a receiver falling behind gets the latest report rather than a
+// stale one.
+func TestDrainingLatest(t *testing.T) {
+	server := NewServer()
+	_, exitchan := startServer(t, server, nil)
+
+	progress := server.Draining(5 * time.Millisecond)
+	server.StartRoutine()
+	server.StartRoutine()
+	server.Close()
+	time.Sleep(20 * time.Millisecond)
+	server.FinishRoutine()
+	time.Sleep(50 * time.Millisecond)
+
+	if p := <-progress; p.Routines != 1 {
+		t.Errorf("expected the latest report with 1 outstanding routine, got %+v", p)
+	}
+
+	server.FinishRoutine()
+	for range progress {
+	}
+	if err := <-exitchan; err != nil {
+		t.Error("Unexpected error during shutdown", err)
+	}
+}
+
+// Tests that Draining rejects a non-positive interval when it is called
+// rather than crashing once the server is closed.
+func TestDrainingNonPositiveInterval(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected Draining to panic on a zero interval")
+		}
+	}()
+	NewServer().Draining(0)
+}
@@ -0,0 +1,39 @@
+package manners
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrServerClosed is returned by Serve when it is called on a server that is
+// still shutting down.
+var ErrServerClosed = errors.New("manners: server is shutting down")
+
+// A ShutdownTimeoutError is returned by Serve when the ShutdownPolicy gave up
+// waiting for the server to drain. It lists what was left at that point.
+type ShutdownTimeoutError struct {
+	DrainProgress
+}
+
+func (e *ShutdownTimeoutError) Error() string {
+	return fmt.Sprintf("manners: shutdown timed out after %v with %d connections, %d requests and %d routines left",
+		e.Elapsed, e.Connections, e.InFlight, e.Routines)
+}
+
+// A ListenerCloseError is returned by Serve, joined with any other error,
+// for each error returned while closing the listener on shutdown.
+type ListenerCloseError struct {
+	Err error
+}
+
+func (e *ListenerCloseError) Error() string {
+	return "manners: closing listener: " + e.Err.Error()
+}
+
+func (e *ListenerCloseError) Unwrap() error {
+	return e.Err
+}
+
+func (s *GracefulServer) shutdownTimeoutError() error {
+	return &ShutdownTimeoutError{s.drainProgress()}
+}
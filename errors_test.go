@@ -0,0 +1,99 @@
+package manners
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingListener is a listener whose Close always fails.
+type failingListener struct {
+	net.Listener
+}
+
+func (l failingListener) Close() error {
+	l.Listener.Close()
+	return errors.New("close failed")
+}
+
+// Tests that an error closing the listener is returned by Serve and logged
+// through the server's ErrorLog.
+func TestListenerCloseError(t *testing.T) {
+	var logged bytes.Buffer
+	server := NewServer()
+	server.ErrorLog = log.New(&logged, "", 0)
+	_, exitchan := startGenericServer(t, server, nil, func() error {
+		l, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			return err
+		}
+		return server.Serve(failingListener{l})
+	})
+
+	server.Close()
+	err := <-exitchan
+	var lerr *ListenerCloseError
+	if !errors.As(err, &lerr) || lerr.Err.Error() != "close failed" {
+		t.Fatalf("Expected a ListenerCloseError, got %v", err)
+	}
+	if !strings.Contains(logged.String(), "close failed") {
+		t.Errorf("Expected the error to be logged, got %q", logged.String())
+	}
+}
+
+// acceptErrorListener fails every Accept with err.
+type acceptErrorListener struct {
+	net.Listener
+	err error
+}
+
+func (l acceptErrorListener) Accept() (net.Conn, error) {
+	return nil, l.err
+}
+
+// Tests that an Accept failure is returned by Serve.
+func TestAcceptError(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer()
+	server.Handler = nullHandler
+	acceptErr := errors.New("accept failed")
+	if err := server.Serve(acceptErrorListener{l, acceptErr}); !errors.Is(err, acceptErr) {
+		t.Errorf("Expected the Accept error, got %v", err)
+	}
+}
+
+// Tests that Serve returns ErrServerClosed while the server is shutting down.
+func TestServeWhileShuttingDown(t *testing.T) {
+	server := NewServer()
+	_, exitchan := startServer(t, server, nil)
+
+	server.StartRoutine()
+	server.Close()
+	waitForPhase(t, server, PhaseShuttingDown)
+
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := server.Serve(l); err != ErrServerClosed {
+		t.Errorf("Expected ErrServerClosed, got %v", err)
+	}
+
+	server.FinishRoutine()
+	select {
+	case err := <-exitchan:
+		if err != nil {
+			t.Error("Unexpected error during shutdown", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return")
+	}
+}
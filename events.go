@@ -0,0 +1,175 @@
+package manners
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// An EventSink receives the events emitted by a GracefulServer. Event is
+// called synchronously from the server's connection handling, so it should
+// return quickly.
+type EventSink interface {
+	Event(Event)
+}
+
+// EventKind identifies what an Event reports.
+type EventKind int
+
+const (
+	// EventAccept reports a newly accepted connection.
+	EventAccept EventKind = iota
+	// EventActive reports a connection starting to read a request.
+	EventActive
+	// EventIdle reports a kept-alive connection waiting for a new request.
+	EventIdle
+	// EventHijack reports a connection hijacked from the server.
+	EventHijack
+	// EventClose reports a closed connection.
+	EventClose
+	// EventForcedClose reports a connection closed by the server because
+	// the shutdown policy gave up waiting for it.
+	EventForcedClose
+	// EventPhase reports the server moving to a new Phase.
+	EventPhase
+	// EventDrained reports that every connection and routine the server was
+	// waiting for has finished.
+	EventDrained
+)
+
+var eventKindNames = []string{
+	"accept", "active", "idle", "hijack", "close", "forced_close", "phase", "drained",
+}
+
+func (k EventKind) String() string {
+	if k < 0 || int(k) >= len(eventKindNames) {
+		return "unknown"
+	}
+	return eventKindNames[k]
+}
+
+// An Event is a single entry in a GracefulServer's event log.
+type Event struct {
+	Time time.Time
+	Kind EventKind
+
+	// ConnID and RemoteAddr identify the connection of a connection event.
+	// IDs are assigned in order of acceptance, starting at 1.
+	ConnID     uint64
+	RemoteAddr string
+
+	// Phase is the phase entered, for EventPhase.
+	Phase Phase
+}
+
+var connEventKinds = map[http.ConnState]EventKind{
+	http.StateNew:      EventAccept,
+	http.StateActive:   EventActive,
+	http.StateIdle:     EventIdle,
+	http.StateHijacked: EventHijack,
+	http.StateClosed:   EventClose,
+}
+
+// emit timestamps e and passes it to the server's EventSink, if any.
+func (s *GracefulServer) emit(e Event) {
+	if s.Events == nil {
+		return
+	}
+	e.Time = time.Now()
+	s.Events.Event(e)
+}
+
+// connEvent emits the event for conn changing to state.
+func (s *GracefulServer) connEvent(conn net.Conn, id uint64, state http.ConnState) {
+	if s.Events == nil {
+		return
+	}
+	e := Event{Kind: connEventKinds[state], ConnID: id}
+	e.RemoteAddr = remoteAddr(conn)
+	s.emit(e)
+}
+
+// remoteAddr returns the address of the peer of conn without blocking: a
+// ProxyListener connection, even wrapped in a *tls.Conn, would otherwise wait
+// for its PROXY header in the server's accept loop.
+func remoteAddr(conn net.Conn) string {
+	for c := conn; ; {
+		if pc, ok := c.(*proxyConn); ok {
+			return pc.peekRemoteAddr().String()
+		}
+		w, ok := c.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		c = w.NetConn()
+	}
+	if addr := conn.RemoteAddr(); addr != nil {
+		return addr.String()
+	}
+	return ""
+}
+
+// SlogSink returns an EventSink that logs every event to logger at level
+// Info, or Debug for connection events.
+func SlogSink(logger *slog.Logger) EventSink {
+	return slogSink{logger}
+}
+
+type slogSink struct {
+	logger *slog.Logger
+}
+
+func (ss slogSink) Event(e Event) {
+	attrs := []slog.Attr{slog.Time("time", e.Time)}
+	level := slog.LevelDebug
+	switch e.Kind {
+	case EventPhase:
+		level = slog.LevelInfo
+		attrs = append(attrs, slog.String("phase", e.Phase.String()))
+	case EventDrained, EventForcedClose:
+		level = slog.LevelInfo
+	}
+	if e.ConnID != 0 {
+		attrs = append(attrs, slog.Uint64("conn", e.ConnID), slog.String("remote_addr", e.RemoteAddr))
+	}
+	ss.logger.LogAttrs(context.Background(), level, "manners: "+e.Kind.String(), attrs...)
+}
+
+// JSONSink returns an EventSink that writes every event to w as a line of
+// JSON. Writes are serialized, so w need not be safe for concurrent use.
+func JSONSink(w io.Writer) EventSink {
+	return &jsonSink{enc: json.NewEncoder(w)}
+}
+
+type jsonSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+type jsonEvent struct {
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event"`
+	ConnID     uint64    `json:"conn,omitempty"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	Phase      string    `json:"phase,omitempty"`
+}
+
+func (js *jsonSink) Event(e Event) {
+	je := jsonEvent{
+		Time:       e.Time,
+		Event:      e.Kind.String(),
+		ConnID:     e.ConnID,
+		RemoteAddr: e.RemoteAddr,
+	}
+	if e.Kind == EventPhase {
+		je.Phase = e.Phase.String()
+	}
+	js.mu.Lock()
+	js.enc.Encode(je)
+	js.mu.Unlock()
+}
@@ -0,0 +1,59 @@
+package manners
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// Tests that a connection's lifecycle and the server shutdown are written to
+// a JSONSink in order.
+func TestJSONSinkEvents(t *testing.T) {
+	var buf bytes.Buffer
+	server := NewServer()
+	server.Events = JSONSink(&buf)
+	statechanged := make(chan http.ConnState, 100)
+	addr, exitchan := startServer(t, server, statechanged)
+
+	client := newClient(addr, false)
+	client.Run()
+	<-client.connected
+	client.sendrequest <- true
+	<-client.response
+	close(client.sendrequest)
+	<-client.closed
+	waitForState(t, statechanged, http.StateClosed, "Client failed to reach closed state")
+
+	server.Close()
+	if err := <-exitchan; err != nil {
+		t.Error("Unexpected error during shutdown", err)
+	}
+
+	var got []string
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var e struct {
+			Event  string `json:"event"`
+			ConnID uint64 `json:"conn"`
+			Phase  string `json:"phase"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("invalid event line %q: %s", scanner.Text(), err)
+		}
+		if e.ConnID != 0 && e.ConnID != 1 {
+			t.Errorf("expected connection id 1, got %d", e.ConnID)
+		}
+		got = append(got, e.Event+e.Phase)
+	}
+
+	expected := []string{
+		"phaseserving", "accept", "active", "idle", "close",
+		"phaseshutting down", "drained", "phasestopped",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected events %v, got %v", expected, got)
+	}
+}
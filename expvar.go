@@ -0,0 +1,56 @@
+package manners
+
+import (
+	"expvar"
+	"sync"
+)
+
+var (
+	expvarLock sync.Mutex
+	// expvarServers holds the server published under each name, so that
+	// serving a server again does not count as a collision.
+	expvarServers = make(map[string]*GracefulServer)
+)
+
+// publishExpvar publishes the server's statistics through expvar under
+// s.Name. It does nothing if Name is empty or the server is already
+// published, and logs the collision if the name is taken by anything else.
+func (s *GracefulServer) publishExpvar() {
+	if s.Name == "" {
+		return
+	}
+	expvarLock.Lock()
+	defer expvarLock.Unlock()
+	if expvarServers[s.Name] == s {
+		return
+	}
+	if expvar.Get(s.Name) != nil {
+		s.logf("manners: not publishing statistics: expvar name %q is already taken", s.Name)
+		return
+	}
+	expvar.Publish(s.Name, expvar.Func(s.expvarStats))
+	expvarServers[s.Name] = s
+}
+
+// expvarStats renders Stats as a JSON-friendly map.
+func (s *GracefulServer) expvarStats() interface{} {
+	st := s.Stats()
+	conns := make(map[string]int, len(st.Connections))
+	for state, n := range st.Connections {
+		conns[state.String()] = n
+	}
+	return map[string]interface{}{
+		"connections":       conns,
+		"in_flight":         st.InFlight,
+		"routines":          st.Routines,
+		"accepted":          st.Accepted,
+		"closed":            st.Closed,
+		"hijacked":          st.Hijacked,
+		"rejected":          st.Rejected,
+		"forced_closed":     st.ForcedClosed,
+		"panics":            st.Panics,
+		"listeners":         st.Listeners,
+		"phase":             st.Phase.String(),
+		"shutdown_duration": st.ShutdownDuration.Seconds(),
+	}
+}
@@ -0,0 +1,85 @@
+package manners
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExpvar(t *testing.T) {
+	server := NewServer()
+	// expvar names can only be published once per process.
+	server.Name = fmt.Sprintf("manners-test-expvar-%d", time.Now().UnixNano())
+	addr, exitchan := startServer(t, server, nil)
+
+	client := newClient(addr, false)
+	client.Run()
+	<-client.connected
+	client.sendrequest <- true
+	<-client.response
+
+	v := expvar.Get(server.Name)
+	if v == nil {
+		t.Fatal("expected the server statistics to be published")
+	}
+	var published struct {
+		Phase     string            `json:"phase"`
+		Listeners map[string]uint64 `json:"listeners"`
+	}
+	if err := json.Unmarshal([]byte(v.String()), &published); err != nil {
+		t.Fatal("published statistics are not valid JSON", err)
+	}
+	if published.Phase != "serving" {
+		t.Errorf("expected phase serving, got %q", published.Phase)
+	}
+	if n := published.Listeners[addr.String()]; n != 1 {
+		t.Errorf("expected 1 connection accepted on %s, got %d", addr, n)
+	}
+
+	close(client.sendrequest)
+	<-client.closed
+	server.Close()
+	<-exitchan
+}
+
+// Tests that servers publishing under the same name at the same time do not
+// panic, and that the ones that lose log the collision.
+func TestExpvarCollision(t *testing.T) {
+	name := fmt.Sprintf("manners-test-expvar-collision-%d", time.Now().UnixNano())
+	var mu sync.Mutex
+	var buf bytes.Buffer
+	var wg sync.WaitGroup
+	servers := make([]*GracefulServer, 4)
+	for i := range servers {
+		servers[i] = NewServer()
+		servers[i].Name = name
+		servers[i].ErrorLog = log.New(&lockedWriter{mu: &mu, w: &buf}, "", 0)
+		wg.Add(1)
+		go func(s *GracefulServer) {
+			defer wg.Done()
+			s.publishExpvar()
+		}(servers[i])
+	}
+	wg.Wait()
+
+	if n := strings.Count(buf.String(), "already taken"); n != len(servers)-1 {
+		t.Errorf("expected %d collisions logged, got %d:\n%s", len(servers)-1, n, buf.String())
+	}
+}
+
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  *bytes.Buffer
+}
+
+func (lw *lockedWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return lw.w.Write(p)
+}
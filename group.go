@@ -0,0 +1,170 @@
+package manners
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A ServerGroup runs several GracefulServers side by side, for example a
+// public API server, an admin server and a metrics server, and shuts them
+// down one after the other.
+//
+// eg.
+//
+//	g := manners.NewServerGroup()
+//	g.Timeout = 30 * time.Second
+//	g.Add(public, nil)
+//	g.Add(admin, func() error { return admin.ListenAndServeTLS(cert, key) })
+//
+//	go func() {
+//		<-sigchan
+//		g.Close()
+//	}()
+//	log.Fatal(g.Run())
+type ServerGroup struct {
+	// Timeout bounds how long Close waits for the servers to drain, in total.
+	// Zero means Close waits as long as it takes.
+	Timeout time.Duration
+
+	mu      sync.Mutex
+	members []*groupMember
+	closed  bool // set by Close, so that a later Run returns at once.
+}
+
+type groupMember struct {
+	server  *GracefulServer
+	serve   func() error
+	started bool          // set by Run, guarded by the group's mu.
+	done    chan struct{} // closed when serve has returned.
+}
+
+// NewServerGroup creates an empty ServerGroup.
+func NewServerGroup() *ServerGroup {
+	return &ServerGroup{}
+}
+
+// Add adds s to the group. serve is the function Run calls to start s; if it
+// is nil, s.ListenAndServe is used. Close drains the servers in the order
+// they were added.
+func (g *ServerGroup) Add(s *GracefulServer, serve func() error) {
+	if serve == nil {
+		serve = s.ListenAndServe
+	}
+	g.mu.Lock()
+	g.members = append(g.members, &groupMember{
+		server: s,
+		serve:  serve,
+		done:   make(chan struct{}),
+	})
+	g.mu.Unlock()
+}
+
+// Run starts every server in the group and blocks until all of them have
+// stopped. If a server fails, Run closes the rest of the group. It returns
+// the first error returned by a server, or nil at once if the group has
+// already been closed.
+func (g *ServerGroup) Run() error {
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		return nil
+	}
+	members := append([]*groupMember(nil), g.members...)
+	for _, m := range members {
+		m.started = true
+	}
+	g.mu.Unlock()
+
+	errs := make(chan error, len(members))
+	for _, m := range members {
+		go func(m *groupMember) {
+			err := m.serve()
+			close(m.done)
+			errs <- err
+		}(m)
+	}
+
+	var first error
+	for range members {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+			go g.Close()
+		}
+	}
+	return first
+}
+
+// Close shuts the servers down in the order they were added, waiting for
+// each to drain before closing the next. If the group's Timeout passes first,
+// the remaining servers are all closed at once and Close returns an error
+// naming the servers that had not finished draining. Closing a group that
+// has not been run yet makes Run return at once.
+func (g *ServerGroup) Close() error {
+	var deadline <-chan time.Time
+	if g.Timeout > 0 {
+		timer := time.NewTimer(g.Timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	g.mu.Lock()
+	g.closed = true
+	g.mu.Unlock()
+
+	members := g.startedMembers()
+	for i, m := range members {
+		select {
+		case <-m.done:
+			// Already stopped, possibly without ever serving.
+			continue
+		default:
+		}
+
+		m.server.Close()
+		select {
+		case <-m.done:
+		case <-deadline:
+			var pending []string
+			for _, rest := range members[i:] {
+				select {
+				case <-rest.done:
+				default:
+					if rest != m {
+						rest.server.Close()
+					}
+					pending = append(pending, serverName(rest.server))
+				}
+			}
+			return fmt.Errorf("manners: servers did not drain within %s: %s",
+				g.Timeout, strings.Join(pending, ", "))
+		}
+	}
+	return nil
+}
+
+// startedMembers returns the members Run has started.
+func (g *ServerGroup) startedMembers() []*groupMember {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var started []*groupMember
+	for _, m := range g.members {
+		if m.started {
+			started = append(started, m)
+		}
+	}
+	return started
+}
+
+// serverName names s in errors: by its Name if set, otherwise by the address
+// it listens on.
+func serverName(s *GracefulServer) string {
+	if s.Name != "" {
+		return s.Name
+	}
+	if addr := s.ListenerAddr(); addr != nil {
+		return addr.String()
+	}
+	return s.Addr
+}
@@ -0,0 +1,166 @@
+package manners
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startGroup runs g and waits for every server in it to be listening.
+func startGroup(t *testing.T, g *ServerGroup, servers ...*GracefulServer) chan error {
+	for _, s := range servers {
+		s.Addr = "localhost:0"
+		s.Handler = nullHandler
+		g.Add(s, nil)
+	}
+	exitchan := make(chan error, 1)
+	go func() {
+		exitchan <- g.Run()
+	}()
+	for _, s := range servers {
+		select {
+		case <-s.Ready():
+		case err := <-exitchan:
+			t.Fatal("Group failed to start", err)
+		}
+	}
+	return exitchan
+}
+
+func waitForPhase(t *testing.T, s *GracefulServer, p Phase) {
+	for i := 0; s.Phase() != p; i++ {
+		if i == 100 {
+			t.Fatalf("server did not reach phase %s, still %s", p, s.Phase())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Tests that a group drains its servers one at a time, in order.
+func TestServerGroupCloseOrder(t *testing.T) {
+	public, admin := NewServer(), NewServer()
+	g := NewServerGroup()
+	exitchan := startGroup(t, g, public, admin)
+
+	public.StartRoutine()
+	closed := make(chan error)
+	go func() {
+		closed <- g.Close()
+	}()
+
+	waitForPhase(t, public, PhaseShuttingDown)
+	if p := admin.Phase(); p != PhaseServing {
+		t.Errorf("admin server should keep serving while public drains, got %s", p)
+	}
+
+	public.FinishRoutine()
+	if err := <-closed; err != nil {
+		t.Error("Unexpected error closing group", err)
+	}
+	if err := <-exitchan; err != nil {
+		t.Error("Unexpected error from group", err)
+	}
+	if p := admin.Phase(); p != PhaseStopped {
+		t.Errorf("admin server should be stopped, got %s", p)
+	}
+}
+
+// Tests that servers still draining when the group's timeout passes are
+// reported, and that the remaining servers are closed anyway.
+func TestServerGroupCloseTimeout(t *testing.T) {
+	public, admin := NewServer(), NewServer()
+	g := NewServerGroup()
+	g.Timeout = 50 * time.Millisecond
+	// admin is closed first and drains at once, so only public times out.
+	exitchan := startGroup(t, g, admin, public)
+
+	public.StartRoutine()
+	err := g.Close()
+	publicAddr, adminAddr := public.ListenerAddr().String(), admin.ListenerAddr().String()
+	if err == nil || !strings.Contains(err.Error(), publicAddr) || strings.Contains(err.Error(), adminAddr) {
+		t.Errorf("expected a timeout error naming only %s, got %v", publicAddr, err)
+	}
+	waitForPhase(t, admin, PhaseStopped)
+
+	public.FinishRoutine()
+	if err := <-exitchan; err != nil {
+		t.Error("Unexpected error from group", err)
+	}
+}
+
+// Tests that a server failing to start closes the rest of the group and that
+// Run reports the failure.
+func TestServerGroupFailure(t *testing.T) {
+	public, broken := NewServer(), NewServer()
+	public.Addr = "localhost:0"
+	broken.Addr = "localhost:-1"
+
+	g := NewServerGroup()
+	g.Add(public, nil)
+	g.Add(broken, nil)
+
+	if err := g.Run(); err == nil {
+		t.Error("expected the broken server's error")
+	}
+	if p := public.Phase(); p != PhaseStopped {
+		t.Errorf("public server should be stopped, got %s", p)
+	}
+}
+
+// Tests that closing a group before running it returns at once and makes
+// the later Run return instead of serving forever.
+func TestServerGroupCloseBeforeRun(t *testing.T) {
+	server := NewServer()
+	server.Addr = "localhost:0"
+	g := NewServerGroup()
+	g.Add(server, nil)
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- g.Close()
+	}()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Error("Unexpected error closing the group", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close waited for a server that was never started")
+	}
+
+	exitchan := make(chan error, 1)
+	go func() {
+		exitchan <- g.Run()
+	}()
+	select {
+	case err := <-exitchan:
+		if err != nil {
+			t.Error("Unexpected error from a closed group", err)
+		}
+	case <-time.After(time.Second):
+		server.Close()
+		t.Fatal("Run served a group that was already closed")
+	}
+}
+
+// Tests that the timeout error names servers by Name when they have one.
+func TestServerGroupCloseTimeoutNames(t *testing.T) {
+	public, admin := NewServer(), NewServer()
+	// expvar names can only be published once per process.
+	suffix := fmt.Sprintf("-%d", time.Now().UnixNano())
+	public.Name, admin.Name = "public"+suffix, "admin"+suffix
+	g := NewServerGroup()
+	g.Timeout = 50 * time.Millisecond
+	exitchan := startGroup(t, g, admin, public)
+
+	public.StartRoutine()
+	if err := g.Close(); err == nil || !strings.HasSuffix(err.Error(), ": "+public.Name) {
+		t.Errorf("expected a timeout error naming %s, got %v", public.Name, err)
+	}
+
+	public.FinishRoutine()
+	if err := <-exitchan; err != nil {
+		t.Error("Unexpected error from group", err)
+	}
+}
@@ -75,7 +75,7 @@ func newClient(addr net.Addr, tls bool) *client {
 // a handler that returns 200 ok with no body
 var nullHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
 
-func startGenericServer(t *testing.T, server *GracefulServer, statechanged chan http.ConnState, runner func() error) (l net.Listener, errc chan error) {
+func startGenericServer(t *testing.T, server *GracefulServer, statechanged chan http.ConnState, runner func() error) (addr net.Addr, errc chan error) {
 	server.Addr = "localhost:0"
 	server.Handler = nullHandler
 	if statechanged != nil {
@@ -86,7 +86,6 @@ func startGenericServer(t *testing.T, server *GracefulServer, statechanged chan
 		}
 	}
 
-	server.up = make(chan net.Listener)
 	exitchan := make(chan error)
 
 	go func() {
@@ -95,22 +94,22 @@ func startGenericServer(t *testing.T, server *GracefulServer, statechanged chan
 
 	// wait for server socket to be bound
 	select {
-	case l = <-server.up:
+	case <-server.Ready():
 		// all good
 
 	case err := <-exitchan:
 		// all bad
 		t.Fatal("Server failed to start", err)
 	}
-	return l, exitchan
+	return server.ListenerAddr(), exitchan
 }
 
 func startServer(t *testing.T, server *GracefulServer, statechanged chan http.ConnState) (
-	l net.Listener, errc chan error) {
+	addr net.Addr, errc chan error) {
 	return startGenericServer(t, server, statechanged, server.ListenAndServe)
 }
 
-func startTLSServer(t *testing.T, server *GracefulServer, certFile, keyFile string, statechanged chan http.ConnState) (l net.Listener, errc chan error) {
+func startTLSServer(t *testing.T, server *GracefulServer, certFile, keyFile string, statechanged chan http.ConnState) (addr net.Addr, errc chan error) {
 	runner := func() error {
 		return server.ListenAndServeTLS(certFile, keyFile)
 	}
@@ -0,0 +1,88 @@
+package manners
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// A LongPoller answers long-poll requests, giving up early when the server
+// starts shutting down.
+type LongPoller struct {
+	// Timeout bounds how long a request waits for data. Zero means waiting
+	// until the client goes away or the server shuts down.
+	Timeout time.Duration
+
+	// RetryStatus is the status sent when the server starts shutting down
+	// while the request waits, along with "Connection: close" and, if
+	// RetryAfter is positive, a Retry-After header. Zero means 503 Service
+	// Unavailable.
+	RetryStatus int
+	RetryAfter  time.Duration
+}
+
+// DefaultLongPoller is the LongPoller used by LongPoll.
+var DefaultLongPoller = &LongPoller{Timeout: 30 * time.Second, RetryAfter: time.Second}
+
+// LongPoll answers r with the value returned by wait, encoded as JSON, using
+// DefaultLongPoller. See LongPoller.Poll.
+func LongPoll(w http.ResponseWriter, r *http.Request, wait func(ctx context.Context) (interface{}, error)) {
+	DefaultLongPoller.Poll(w, r, wait)
+}
+
+// Poll calls wait and answers r with the value it returns, encoded as JSON,
+// or with 500 Internal Server Error if it fails. The context passed to wait
+// is cancelled, and Poll returns without waiting for wait any longer, if:
+//
+//   - the client goes away, in which case nothing is written;
+//   - the Timeout elapses, in which case Poll answers 204 No Content;
+//   - the server serving r starts shutting down, in which case Poll answers
+//     with RetryStatus so that the client polls again elsewhere.
+func (p *LongPoller) Poll(w http.ResponseWriter, r *http.Request, wait func(ctx context.Context) (interface{}, error)) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	var timeout <-chan time.Time
+	if p.Timeout > 0 {
+		timer := time.NewTimer(p.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var closing <-chan struct{}
+	if s, ok := r.Context().Value(serverContextKey).(*GracefulServer); ok {
+		s.lcsmu.RLock()
+		closing = s.closing
+		s.lcsmu.RUnlock()
+	}
+
+	type result struct {
+		v   interface{}
+		err error
+	}
+	results := make(chan result, 1)
+	go func() {
+		v, err := wait(ctx)
+		results <- result{v, err}
+	}()
+
+	select {
+	case res := <-results:
+		if res.err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res.v)
+	case <-r.Context().Done():
+	case <-timeout:
+		w.WriteHeader(http.StatusNoContent)
+	case <-closing:
+		status := p.RetryStatus
+		if status == 0 {
+			status = http.StatusServiceUnavailable
+		}
+		setRetryAfter(w, p.RetryAfter)
+		w.Header().Set("Connection", "close")
+		http.Error(w, http.StatusText(status), status)
+	}
+}
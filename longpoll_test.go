@@ -0,0 +1,65 @@
+package manners
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Tests that a long poll answers with the data, times out with 204, and
+// gives up with a retry hint when the server shuts down.
+func TestLongPoll(t *testing.T) {
+	server := NewServer()
+	addr, exitchan := startServer(t, server, nil)
+
+	data := make(chan string, 1)
+	waiting := make(chan struct{}, 1)
+	poller := &LongPoller{Timeout: 50 * time.Millisecond, RetryStatus: http.StatusTooManyRequests, RetryAfter: 1500 * time.Millisecond}
+	server.SwapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		poller.Poll(w, r, func(ctx context.Context) (interface{}, error) {
+			waiting <- struct{}{}
+			select {
+			case d := <-data:
+				return map[string]string{"data": d}, nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		})
+	}))
+
+	get := func() (*http.Response, string) {
+		resp, err := http.Get("http://" + addr.String() + "/poll")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp, string(body)
+	}
+
+	data <- "hello"
+	if resp, body := get(); resp.StatusCode != http.StatusOK || body != "{\"data\":\"hello\"}\n" {
+		t.Errorf("Expected the data, got %d %q", resp.StatusCode, body)
+	}
+	<-waiting
+
+	if resp, _ := get(); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected 204 on timeout, got %d", resp.StatusCode)
+	}
+	<-waiting
+
+	poller.Timeout = 0
+	go func() {
+		<-waiting
+		server.Close()
+	}()
+	resp, _ := get()
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "2" {
+		t.Errorf("Expected 429 with Retry-After: 2, got %d %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	if err := <-exitchan; err != nil {
+		t.Error("Unexpected error during shutdown", err)
+	}
+}
@@ -0,0 +1,85 @@
+package manners
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// MetricsHandler returns an http.Handler that reports the Stats of the given
+// servers in the Prometheus text exposition format. Every sample carries an
+// "addr" label holding the address the server listens on, or its Addr
+// before it starts, and a "name" label holding its Name when set, so several
+// servers can share one endpoint. Servers whose labels are the same would
+// produce duplicate series, so the handler fails rather than report them.
+func MetricsHandler(servers ...*GracefulServer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stats := make([]Stats, len(servers))
+		labels := make([]string, len(servers))
+		seen := make(map[string]bool, len(servers))
+		for i, s := range servers {
+			stats[i] = s.Stats()
+			labels[i] = metricsLabels(s)
+			if seen[labels[i]] {
+				http.Error(w, "manners: several servers share the metrics labels "+labels[i], http.StatusInternalServerError)
+				return
+			}
+			seen[labels[i]] = true
+		}
+
+		var buf bytes.Buffer
+		metric := func(name, kind, help string, value func(Stats) interface{}) {
+			fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+			for i := range servers {
+				fmt.Fprintf(&buf, "%s{%s} %v\n", name, labels[i], value(stats[i]))
+			}
+		}
+
+		fmt.Fprintf(&buf, "# HELP manners_connections Open connections by state.\n")
+		fmt.Fprintf(&buf, "# TYPE manners_connections gauge\n")
+		for i := range servers {
+			for _, state := range []http.ConnState{http.StateNew, http.StateActive, http.StateIdle} {
+				fmt.Fprintf(&buf, "manners_connections{%s,state=\"%s\"} %d\n",
+					labels[i], state, stats[i].Connections[state])
+			}
+		}
+		metric("manners_requests_in_flight", "gauge", "Requests currently being handled.",
+			func(st Stats) interface{} { return st.InFlight })
+		metric("manners_routines", "gauge", "Routines the server waits for before shutting down.",
+			func(st Stats) interface{} { return st.Routines })
+		metric("manners_connections_accepted_total", "counter", "Connections accepted.",
+			func(st Stats) interface{} { return st.Accepted })
+		metric("manners_connections_closed_total", "counter", "Connections closed.",
+			func(st Stats) interface{} { return st.Closed })
+		metric("manners_connections_hijacked_total", "counter", "Connections hijacked from the server.",
+			func(st Stats) interface{} { return st.Hijacked })
+		metric("manners_connections_forced_closed_total", "counter", "Connections closed by the server during shutdown.",
+			func(st Stats) interface{} { return st.ForcedClosed })
+		metric("manners_requests_rejected_total", "counter", "Requests rejected because the server was shutting down.",
+			func(st Stats) interface{} { return st.Rejected })
+		metric("manners_panics_total", "counter", "Panics recovered by the server.",
+			func(st Stats) interface{} { return st.Panics })
+		metric("manners_shutdown_duration_seconds", "gauge", "Time spent shutting down.",
+			func(st Stats) interface{} { return st.ShutdownDuration.Seconds() })
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(buf.Bytes())
+	})
+}
+
+// metricsLabels returns the labels identifying s in the metrics.
+func metricsLabels(s *GracefulServer) string {
+	addr := s.Addr
+	if a := s.ListenerAddr(); a != nil {
+		addr = a.String()
+	}
+	labels := `addr="` + labelEscaper.Replace(addr) + `"`
+	if s.Name != "" {
+		labels += `,name="` + labelEscaper.Replace(s.Name) + `"`
+	}
+	return labels
+}
+
+// labelEscaper escapes label values as the text exposition format asks.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,95 @@
+package manners
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Tests that Stats follows a connection through its states and records the
+// shutdown.
+func TestStats(t *testing.T) {
+	server := NewServer()
+	statechanged := make(chan http.ConnState, 100)
+	addr, exitchan := startServer(t, server, statechanged)
+
+	client := newClient(addr, false)
+	client.Run()
+	<-client.connected
+	client.sendrequest <- true
+	<-client.response
+	waitForState(t, statechanged, http.StateIdle, "Client failed to reach idle state")
+
+	st := server.Stats()
+	if st.Accepted != 1 || st.Connections[http.StateIdle] != 1 || st.InFlight != 0 {
+		t.Errorf("unexpected stats for an idle connection: %+v", st)
+	}
+
+	server.Close()
+	if err := <-exitchan; err != nil {
+		t.Error("Unexpected error during shutdown", err)
+	}
+	waitForState(t, statechanged, http.StateClosed, "Server failed to close the idle connection")
+
+	st = server.Stats()
+	if st.Connections[http.StateIdle] != 0 || st.Closed != 1 {
+		t.Errorf("expected the idle connection to be closed on shutdown: %+v", st)
+	}
+	if st.ShutdownDuration <= 0 {
+		t.Errorf("expected a shutdown duration, got %s", st.ShutdownDuration)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	server := NewServer()
+	server.Addr = "localhost:8080"
+	server.StartRoutine()
+	defer server.FinishRoutine()
+
+	w := httptest.NewRecorder()
+	MetricsHandler(server).ServeHTTP(w, &http.Request{Method: "GET"})
+
+	body := w.Body.String()
+	for _, line := range []string{
+		"# TYPE manners_connections gauge",
+		`manners_connections{addr="localhost:8080",state="idle"} 0`,
+		`manners_routines{addr="localhost:8080"} 1`,
+		`manners_requests_rejected_total{addr="localhost:8080"} 0`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected metrics output to contain %q, got:\n%s", line, body)
+		}
+	}
+}
+
+// Tests that servers are told apart by their name and that label values are
+// escaped.
+func TestMetricsHandlerLabels(t *testing.T) {
+	first, second := NewServer(), NewServer()
+	first.Name = `api "v1"`
+	second.Name = "admin\\\n"
+
+	w := httptest.NewRecorder()
+	MetricsHandler(first, second).ServeHTTP(w, &http.Request{Method: "GET"})
+
+	body := w.Body.String()
+	for _, line := range []string{
+		`manners_routines{addr="",name="api \"v1\""} 0`,
+		`manners_routines{addr="",name="admin\\\n"} 0`,
+		`manners_connections{addr="",name="admin\\\n",state="new"} 0`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected metrics output to contain %q, got:\n%s", line, body)
+		}
+	}
+}
+
+// Tests that servers that cannot be told apart are not reported.
+func TestMetricsHandlerDuplicateLabels(t *testing.T) {
+	w := httptest.NewRecorder()
+	MetricsHandler(NewServer(), NewServer()).ServeHTTP(w, &http.Request{Method: "GET"})
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected duplicate labels to be rejected, got %d", w.Code)
+	}
+}
@@ -0,0 +1,53 @@
+// Package otelmanners traces the shutdown of a manners.GracefulServer with
+// OpenTelemetry.
+//
+// eg.
+//
+//	s := manners.NewServer()
+//	s.Tracer = otelmanners.NewTracer(otel.Tracer("manners"))
+package otelmanners
+
+import (
+	"context"
+	"sort"
+
+	"github.com/braintree/manners"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// NewTracer returns a manners.Tracer that records spans with t.
+func NewTracer(t trace.Tracer) manners.Tracer {
+	return tracer{t}
+}
+
+type tracer struct {
+	t trace.Tracer
+}
+
+func (t tracer) Start(ctx context.Context, name string) (context.Context, manners.Span) {
+	ctx, s := t.t.Start(ctx, name)
+	return ctx, span{s}
+}
+
+type span struct {
+	s trace.Span
+}
+
+func (s span) AddEvent(name string, attrs map[string]int64) {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	kvs := make([]attribute.KeyValue, len(keys))
+	for i, k := range keys {
+		kvs[i] = attribute.Int64(k, attrs[k])
+	}
+	s.s.AddEvent(name, trace.WithAttributes(kvs...))
+}
+
+func (s span) End() {
+	s.s.End()
+}
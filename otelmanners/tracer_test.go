@@ -0,0 +1,33 @@
+package otelmanners
+
+import (
+	"context"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracer(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	tracer := NewTracer(provider.Tracer("manners"))
+
+	ctx, root := tracer.Start(context.Background(), "manners.shutdown")
+	_, step := tracer.Start(ctx, "close listener")
+	step.End()
+	root.AddEvent("forced close", map[string]int64{"conn": 7})
+	root.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	if spans[0].Name != "close listener" || spans[0].Parent.SpanID() != spans[1].SpanContext.SpanID() {
+		t.Errorf("expected close listener to be a child of the shutdown span: %+v", spans)
+	}
+	events := spans[1].Events
+	if len(events) != 1 || events[0].Name != "forced close" || events[0].Attributes[0].Value.AsInt64() != 7 {
+		t.Errorf("unexpected shutdown span events %+v", events)
+	}
+}
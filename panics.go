@@ -0,0 +1,141 @@
+package manners
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+)
+
+// A PanicReporter is told about the panics a GracefulServer recovers from its
+// handler when RecoverPanics is set. ReportPanic is called synchronously,
+// before the 500 response is written.
+type PanicReporter interface {
+	ReportPanic(HandlerPanic)
+}
+
+// PanicReporterFunc adapts a function to a PanicReporter.
+type PanicReporterFunc func(HandlerPanic)
+
+// ReportPanic calls f(p).
+func (f PanicReporterFunc) ReportPanic(p HandlerPanic) {
+	f(p)
+}
+
+// A HandlerPanic describes a panic recovered from a handler.
+type HandlerPanic struct {
+	PanicError
+
+	// Method, URL and RemoteAddr describe the request being handled.
+	Method     string
+	URL        string
+	RemoteAddr string
+
+	// Routines is the number of routines the handler started with
+	// StartRequestRoutine and had not finished, which were released so that
+	// the server does not wait for them.
+	Routines int
+}
+
+// requestRoutines counts the routines started on behalf of a request. The
+// count is -1 once the routines have been released.
+type requestRoutines struct {
+	n int32
+}
+
+// routinesContextKey is the request context key under which gracefulHandler
+// stores the request's requestRoutines.
+const routinesContextKey contextKey = 1
+
+func (rr *requestRoutines) start() bool {
+	for {
+		n := atomic.LoadInt32(&rr.n)
+		if n < 0 {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&rr.n, n, n+1) {
+			return true
+		}
+	}
+}
+
+func (rr *requestRoutines) finish() bool {
+	for {
+		n := atomic.LoadInt32(&rr.n)
+		if n <= 0 {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&rr.n, n, n-1) {
+			return true
+		}
+	}
+}
+
+// release stops tracking the routines and returns how many were running.
+func (rr *requestRoutines) release() int {
+	if n := atomic.SwapInt32(&rr.n, -1); n > 0 {
+		return int(n)
+	}
+	return 0
+}
+
+func withRequestRoutines(r *http.Request) (*http.Request, *requestRoutines) {
+	rr := new(requestRoutines)
+	return r.WithContext(context.WithValue(r.Context(), routinesContextKey, rr)), rr
+}
+
+// StartRequestRoutine is like StartRoutine for a routine started by the
+// handler of r, which must be paired with FinishRequestRoutine. If the
+// handler panics, the routines it started and has not finished are finished
+// on its behalf, so that they cannot hold up the server's shutdown; the
+// calls to FinishRequestRoutine made for them afterwards have no effect, as
+// do those to StartRequestRoutine.
+func (s *GracefulServer) StartRequestRoutine(r *http.Request) {
+	rr, ok := r.Context().Value(routinesContextKey).(*requestRoutines)
+	if !ok {
+		s.StartRoutine()
+		return
+	}
+	if rr.start() {
+		s.StartRoutine()
+	}
+}
+
+// FinishRequestRoutine is like FinishRoutine for a routine started with
+// StartRequestRoutine.
+func (s *GracefulServer) FinishRequestRoutine(r *http.Request) {
+	rr, ok := r.Context().Value(routinesContextKey).(*requestRoutines)
+	if !ok {
+		s.FinishRoutine()
+		return
+	}
+	if rr.finish() {
+		s.FinishRoutine()
+	}
+}
+
+// releaseRoutines finishes n routines at once.
+func (s *GracefulServer) releaseRoutines(n int) {
+	s.lcsmu.Lock()
+	defer s.lcsmu.Unlock()
+	s.wg.Add(-n)
+	s.routinesCount -= n
+}
+
+// recoveringPanics reports whether the handler should recover panics.
+func (s *GracefulServer) recoveringPanics() bool {
+	return s.RecoverPanics
+}
+
+// handlerPanicked reports a panic recovered from the handler and answers the
+// request with 500 Internal Server Error.
+func (s *GracefulServer) handlerPanicked(w http.ResponseWriter, r *http.Request, p HandlerPanic) {
+	atomic.AddUint64(&s.counters.panics, 1)
+	p.Method, p.URL, p.RemoteAddr = r.Method, r.URL.String(), r.RemoteAddr
+	if s.PanicReporter != nil {
+		s.PanicReporter.ReportPanic(p)
+	} else {
+		s.logf("%v serving %s %s for %s\n%s", &p.PanicError, p.Method, p.URL, p.RemoteAddr, p.Stack)
+	}
+	w.Header().Set("Connection", "close")
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
@@ -0,0 +1,90 @@
+package manners
+
+import (
+	"io"
+	"net/http"
+	"testing"
+)
+
+// Tests that a recovered panic is answered with a 500, reported, and that
+// the routines started by the panicking handler are released.
+func TestRecoverPanics(t *testing.T) {
+	server := NewServer()
+	server.RecoverPanics = true
+	reported := make(chan HandlerPanic, 1)
+	server.PanicReporter = PanicReporterFunc(func(p HandlerPanic) {
+		reported <- p
+	})
+	addr, exitchan := startServer(t, server, nil)
+	server.SwapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.StartRequestRoutine(r)
+		panic("boom")
+	}))
+
+	resp, err := http.Get("http://" + addr.String() + "/panic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Expected 500, got %d", resp.StatusCode)
+	}
+
+	p := <-reported
+	if p.Value != "boom" || p.Method != "GET" || p.URL != "/panic" || p.Routines != 1 || len(p.Stack) == 0 {
+		t.Errorf("Unexpected panic report %+v", p)
+	}
+	if server.Stats().Panics != 1 {
+		t.Errorf("Expected 1 panic counted, got %d", server.Stats().Panics)
+	}
+
+	server.Close()
+	if err := <-exitchan; err != nil {
+		t.Error("Unexpected error during shutdown", err)
+	}
+	if server.RoutinesCount() != 0 {
+		t.Errorf("Expected no routines left, got %d", server.RoutinesCount())
+	}
+}
+
+// Tests that routines finished after the handler panicked are not finished
+// twice.
+func TestRequestRoutinesReleasedOnce(t *testing.T) {
+	server := NewServer()
+	server.StartRoutine()
+	defer server.FinishRoutine()
+
+	finish := make(chan struct{})
+	finished := make(chan struct{})
+	gh := newGracefulHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.StartRequestRoutine(r)
+		go func() {
+			<-finish
+			server.FinishRequestRoutine(r)
+			close(finished)
+		}()
+		panic("boom")
+	}), &server.counters)
+	gh.releaseRoutines = server.releaseRoutines
+	gh.recoverPanics = func() bool { return true }
+	gh.panicked = func(http.ResponseWriter, *http.Request, HandlerPanic) {}
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	gh.ServeHTTP(discardResponse{}, r)
+	if server.RoutinesCount() != 1 {
+		t.Errorf("Expected only the outer routine left, got %d", server.RoutinesCount())
+	}
+
+	close(finish)
+	<-finished
+	if server.RoutinesCount() != 1 {
+		t.Errorf("Expected the released routine not to be finished again, got %d routines", server.RoutinesCount())
+	}
+}
+
+type discardResponse struct{}
+
+func (discardResponse) Header() http.Header         { return http.Header{} }
+func (discardResponse) Write(b []byte) (int, error) { return len(b), nil }
+func (discardResponse) WriteHeader(int)             {}
@@ -0,0 +1,119 @@
+package manners
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Pause stops the server from accepting new connections without shutting
+// it down. Connections that arrive while the server is paused wait in the
+// listen backlog until Resume is called; requests on connections that are
+// already open keep being served, unless RejectWhilePaused is set. Pausing
+// a server that is not serving yet makes it start paused.
+func (s *GracefulServer) Pause() {
+	s.lcsmu.Lock()
+	if s.resumed != nil {
+		s.lcsmu.Unlock()
+		return
+	}
+	s.resumed = make(chan struct{})
+	atomic.StoreInt32(&s.paused, 1)
+	s.lcsmu.Unlock()
+	s.swapPhase(PhaseServing, PhasePaused)
+}
+
+// Resume makes a paused server accept connections again.
+func (s *GracefulServer) Resume() {
+	s.lcsmu.Lock()
+	if s.resumed == nil {
+		s.lcsmu.Unlock()
+		return
+	}
+	close(s.resumed)
+	s.resumed = nil
+	atomic.StoreInt32(&s.paused, 0)
+	s.lcsmu.Unlock()
+	s.swapPhase(PhasePaused, PhaseServing)
+}
+
+// Paused reports whether the server is paused.
+func (s *GracefulServer) Paused() bool {
+	return atomic.LoadInt32(&s.paused) == 1
+}
+
+// rejectingPaused reports whether requests should be turned away because the
+// server is paused.
+func (s *GracefulServer) rejectingPaused() bool {
+	return s.RejectWhilePaused && s.Paused()
+}
+
+// gatePaused wraps l so that it does not hand out connections while the
+// server is paused.
+func (s *GracefulServer) gatePaused(l net.Listener) net.Listener {
+	return &pausableListener{Listener: l, server: s, closed: make(chan struct{})}
+}
+
+// pausableListener holds back the connections it accepts while its server is
+// paused. A connection accepted just as the server is paused is held until
+// the server is resumed.
+type pausableListener struct {
+	net.Listener
+	server    *GracefulServer
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *pausableListener) Accept() (net.Conn, error) {
+	if err := l.waitResumed(); err != nil {
+		return nil, err
+	}
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		select {
+		case <-l.closed:
+			// Whatever the listener returns once closed, Serve only needs to
+			// know that it was closed on purpose.
+			return nil, net.ErrClosed
+		default:
+		}
+		return nil, err
+	}
+	if err := l.waitResumed(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// waitResumed blocks while the server is paused. It returns an error if the
+// listener is closed in the meantime.
+func (l *pausableListener) waitResumed() error {
+	for {
+		l.server.lcsmu.RLock()
+		resumed := l.server.resumed
+		l.server.lcsmu.RUnlock()
+		if resumed == nil {
+			return nil
+		}
+		select {
+		case <-resumed:
+		case <-l.closed:
+			return net.ErrClosed
+		}
+	}
+}
+
+func (l *pausableListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+	return l.Listener.Close()
+}
+
+// rejectPaused answers a request that arrived while the server was paused.
+func rejectPaused(w http.ResponseWriter) {
+	w.Header().Set("Connection", "close")
+	http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+}
@@ -0,0 +1,84 @@
+package manners
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Tests that a paused server holds back new connections until it is resumed
+// and keeps serving the connections it already has.
+func TestPauseResume(t *testing.T) {
+	server := NewServer()
+	statechanged := make(chan http.ConnState, 100)
+	addr, exitchan := startServer(t, server, statechanged)
+
+	client1 := newClient(addr, false)
+	client1.Run()
+	<-client1.connected
+	waitForState(t, statechanged, http.StateNew, "Request not received")
+
+	server.Pause()
+	if p := server.Phase(); p != PhasePaused {
+		t.Errorf("expected phase paused, got %s", p)
+	}
+
+	// The connection is accepted by the kernel, but not by the server.
+	client2 := newClient(addr, false)
+	client2.Run()
+	<-client2.connected
+	select {
+	case state := <-statechanged:
+		t.Fatalf("paused server handed out a connection, state %s", state)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	client1.sendrequest <- true
+	if rr := <-client1.response; rr.err != nil || len(rr.body) == 0 {
+		t.Errorf("existing connection was not served while paused: %v", rr)
+	}
+
+	server.Resume()
+	waitForState(t, statechanged, http.StateNew, "Connection not accepted after resume")
+	client2.sendrequest <- true
+	if rr := <-client2.response; rr.err != nil || len(rr.body) == 0 {
+		t.Errorf("held back connection was not served after resume: %v", rr)
+	}
+
+	close(client1.sendrequest)
+	close(client2.sendrequest)
+	<-client1.closed
+	<-client2.closed
+	server.Close()
+	if err := <-exitchan; err != nil {
+		t.Error("Unexpected error during shutdown", err)
+	}
+}
+
+// Tests that requests on existing connections are turned away while paused
+// if RejectWhilePaused is set, and that a paused server can still be closed.
+func TestRejectWhilePaused(t *testing.T) {
+	server := NewServer()
+	server.RejectWhilePaused = true
+	statechanged := make(chan http.ConnState, 100)
+	addr, exitchan := startServer(t, server, statechanged)
+
+	client := newClient(addr, false)
+	client.Run()
+	<-client.connected
+	waitForState(t, statechanged, http.StateNew, "Request not received")
+
+	server.Pause()
+	client.sendrequest <- true
+	rr := <-client.response
+	if len(rr.body) == 0 || rr.body[0] != "HTTP/1.1 503 Service Unavailable" {
+		t.Errorf("expected a 503 while paused, got %v", rr.body)
+	}
+
+	close(client.sendrequest)
+	<-client.closed
+	server.Close()
+	if err := <-exitchan; err != nil {
+		t.Error("Unexpected error during shutdown", err)
+	}
+}
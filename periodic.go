@@ -0,0 +1,67 @@
+package manners
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// A periodicTask is a function registered with Every.
+type periodicTask struct {
+	name     string
+	interval time.Duration
+	fn       func(ctx context.Context)
+	running  int32 // accessed atomically.
+}
+
+// Every runs fn every interval while the server is serving, starting when
+// Serve is called, or at once if the server is already serving. A run is
+// skipped if the previous one has not returned yet. No run is started once
+// Close has been called, and the server waits for a running one to return
+// before shutting down, like for a routine started with Go. Tasks are
+// scheduled again each time the server is served.
+//
+// The name identifies the task in the server's logs. Every panics if
+// interval is not positive, like time.NewTicker.
+func (s *GracefulServer) Every(interval time.Duration, name string, fn func(ctx context.Context)) {
+	if interval <= 0 {
+		panic("manners: non-positive interval for Every")
+	}
+	t := &periodicTask{name: name, interval: interval, fn: fn}
+
+	s.lcsmu.Lock()
+	s.tasks = append(s.tasks, t)
+	scheduling, closing, done := s.scheduling, s.closing, s.done
+	s.lcsmu.Unlock()
+
+	if scheduling {
+		go s.schedule(t, closing, done)
+	}
+}
+
+// schedule runs t every t.interval until closing or done is closed.
+func (s *GracefulServer) schedule(t *periodicTask, closing, done <-chan struct{}) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-closing:
+			return
+		case <-done:
+			return
+		}
+		select {
+		case <-closing:
+			return
+		default:
+		}
+		if !atomic.CompareAndSwapInt32(&t.running, 0, 1) {
+			continue
+		}
+		s.goRoutine("task "+t.name, func(ctx context.Context) {
+			defer atomic.StoreInt32(&t.running, 0)
+			t.fn(ctx)
+		})
+	}
+}
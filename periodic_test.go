@@ -0,0 +1,86 @@
+package manners
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Tests that a periodic task runs while the server serves and stops being
+// scheduled once it is closed.
+func TestEvery(t *testing.T) {
+	server := NewServer()
+	var runs int32
+	server.Every(5*time.Millisecond, "counter", func(ctx context.Context) {
+		atomic.AddInt32(&runs, 1)
+	})
+	if atomic.LoadInt32(&runs) != 0 {
+		t.Fatal("task ran before Serve")
+	}
+
+	_, exitchan := startServer(t, server, nil)
+	for i := 0; atomic.LoadInt32(&runs) < 3; i++ {
+		if i == 100 {
+			t.Fatal("task did not run")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	server.Close()
+	if err := <-exitchan; err != nil {
+		t.Error("Unexpected error during shutdown", err)
+	}
+	n := atomic.LoadInt32(&runs)
+	time.Sleep(20 * time.Millisecond)
+	if atomic.LoadInt32(&runs) != n {
+		t.Error("task ran after the server shut down")
+	}
+}
+
+// Tests that overlapping runs are skipped and that shutdown waits for a
+// running task.
+func TestEveryOverlapAndDrain(t *testing.T) {
+	server := NewServer()
+	var runs int32
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server.Every(time.Millisecond, "slow", func(ctx context.Context) {
+		atomic.AddInt32(&runs, 1)
+		started <- struct{}{}
+		<-release
+	})
+	_, exitchan := startServer(t, server, nil)
+
+	<-started
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(&runs); n != 1 {
+		t.Errorf("Expected overlapping runs to be skipped, got %d runs", n)
+	}
+
+	server.Close()
+	select {
+	case <-exitchan:
+		t.Fatal("Serve returned before the task finished")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	if err := <-exitchan; err != nil {
+		t.Error("Unexpected error during shutdown", err)
+	}
+}
+
+// Tests that Every rejects a non-positive interval when it is called rather
+// than when the server starts the task.
+func TestEveryNonPositiveInterval(t *testing.T) {
+	server := NewServer()
+	defer func() {
+		if recover() == nil {
+			t.Error("expected Every to panic on a zero interval")
+		}
+		if len(server.tasks) != 0 {
+			t.Error("expected the task not to be registered")
+		}
+	}()
+	server.Every(0, "zero", func(ctx context.Context) {})
+}
@@ -0,0 +1,120 @@
+package manners
+
+import (
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// A ShutdownPolicy describes the phases a GracefulServer goes through once
+// Close is called:
+//
+//  1. For PreStopDelay the server keeps serving as before, but reports that
+//     it is not ready, giving load balancers time to stop sending it traffic.
+//  2. The server stops accepting connections and closes idle ones, then waits
+//     up to DrainTimeout for requests and routines to finish.
+//  3. If they have not, the server cancels the contexts of the requests still
+//     running and closes every connection, then waits up to HardTimeout more.
+//  4. Serve returns, whether or not everything has finished.
+//
+// A zero timeout means waiting as long as it takes.
+type ShutdownPolicy struct {
+	PreStopDelay time.Duration
+	DrainTimeout time.Duration
+	HardTimeout  time.Duration
+}
+
+// ShutdownTimings records how long each phase of the last shutdown took.
+type ShutdownTimings struct {
+	PreStop time.Duration // serving while reporting not ready
+	Drain   time.Duration // waiting for requests and routines to finish
+	Hard    time.Duration // waiting after cancelling requests and closing connections
+}
+
+// ShutdownTimings returns how long each phase of the server's last shutdown
+// took, once Serve has returned.
+func (s *GracefulServer) ShutdownTimings() ShutdownTimings {
+	s.lcsmu.RLock()
+	defer s.lcsmu.RUnlock()
+	return s.report.Timings
+}
+
+// IsReady reports whether the server is serving and willing to take new
+// traffic. It turns false as soon as Close is called, before the pre-stop
+// delay, and while the server is paused.
+func (s *GracefulServer) IsReady() bool {
+	return s.Phase() == PhaseServing
+}
+
+// ReadinessHandler returns a handler suitable for a load balancer or
+// orchestrator readiness check: it answers 200 OK while the server IsReady
+// and 503 Service Unavailable otherwise.
+func (s *GracefulServer) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.IsReady() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ready\n"))
+	})
+}
+
+// awaitRoutines waits for routinesDone to be closed for at most timeout,
+// or indefinitely if timeout is zero, tracing the wait as step. It reports
+// whether the routines finished.
+func (s *GracefulServer) awaitRoutines(step string, routinesDone <-chan struct{}, timeout time.Duration) bool {
+	finished := true
+	s.traceStep(step, func() {
+		if timeout <= 0 {
+			<-routinesDone
+			return
+		}
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-routinesDone:
+		case <-timer.C:
+			finished = false
+		}
+	})
+	return finished
+}
+
+// forceClose records the requests still running as aborted, cancels the
+// context of every running request and routine, and closes every connection
+// the server still has open.
+func (s *GracefulServer) forceClose() {
+	s.recordAborted()
+	s.cancelRequests(nil)
+	s.lcsmu.RLock()
+	s.cancelRoutines()
+	s.lcsmu.RUnlock()
+
+	s.lcsmu.RLock()
+	conns := make(map[net.Conn]uint64, len(s.connections))
+	for conn, tc := range s.connections {
+		conns[conn] = tc.id
+	}
+	s.lcsmu.RUnlock()
+
+	for conn, id := range conns {
+		atomic.AddUint64(&s.counters.forcedClosed, 1)
+		s.shutdownEvent("forced close", map[string]int64{"conn": int64(id)})
+		s.emit(Event{Kind: EventForcedClose, ConnID: id, RemoteAddr: remoteAddr(conn)})
+		conn.Close()
+	}
+}
+
+// cancelRequests cancels the base context of the requests served on l, or
+// on every listener if l is nil.
+func (s *GracefulServer) cancelRequests(l net.Listener) {
+	s.lcsmu.Lock()
+	defer s.lcsmu.Unlock()
+	for listener, cancel := range s.cancels {
+		if l == nil || l == listener {
+			cancel()
+			delete(s.cancels, listener)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package manners
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Tests that the server keeps serving, while reporting not ready, during the
+// pre-stop delay.
+func TestPreStopDelay(t *testing.T) {
+	server := NewServer()
+	server.ShutdownPolicy.PreStopDelay = 100 * time.Millisecond
+	addr, exitchan := startServer(t, server, nil)
+
+	if !server.IsReady() {
+		t.Error("expected a serving server to be ready")
+	}
+	server.Close()
+	waitForPhase(t, server, PhasePreStop)
+
+	w := httptest.NewRecorder()
+	server.ReadinessHandler().ServeHTTP(w, &http.Request{Method: "GET"})
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected readiness to fail during pre-stop, got %d", w.Code)
+	}
+
+	client := newClient(addr, false)
+	client.Run()
+	if err := <-client.connected; err != nil {
+		t.Fatal("Client failed to connect during pre-stop", err)
+	}
+	client.sendrequest <- true
+	if rr := <-client.response; rr.err != nil || len(rr.body) == 0 {
+		t.Errorf("request during pre-stop was not served: %v", rr)
+	}
+	close(client.sendrequest)
+	<-client.closed
+
+	if err := <-exitchan; err != nil {
+		t.Error("Unexpected error during shutdown", err)
+	}
+	if timings := server.ShutdownTimings(); timings.PreStop < 100*time.Millisecond {
+		t.Errorf("expected a pre-stop phase of at least 100ms, got %s", timings.PreStop)
+	}
+}
+
+// Tests that requests still running when the drain timeout passes have
+// their contexts cancelled and their connections closed.
+func TestHardShutdown(t *testing.T) {
+	server := NewServer()
+	server.ShutdownPolicy.DrainTimeout = 50 * time.Millisecond
+	statechanged := make(chan http.ConnState, 100)
+	addr, exitchan := startServer(t, server, statechanged)
+	server.SwapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+
+	client := newClient(addr, false)
+	client.Run()
+	<-client.connected
+	client.sendrequest <- true
+	waitForState(t, statechanged, http.StateActive, "Client failed to reach active state")
+
+	server.Close()
+	if err := <-exitchan; err != nil {
+		t.Error("Unexpected error during shutdown", err)
+	}
+	<-client.response
+	close(client.sendrequest)
+	<-client.closed
+
+	timings := server.ShutdownTimings()
+	if timings.Drain < 50*time.Millisecond || timings.Hard <= 0 {
+		t.Errorf("expected a timed out drain followed by a hard phase, got %+v", timings)
+	}
+	if st := server.Stats(); st.ForcedClosed != 1 {
+		t.Errorf("expected 1 forced close, got %d", st.ForcedClosed)
+	}
+}
+
+// Tests that Serve returns once the hard timeout passes even if routines
+// are still outstanding.
+func TestShutdownFinalDeadline(t *testing.T) {
+	server := NewServer()
+	server.ShutdownPolicy.DrainTimeout = 20 * time.Millisecond
+	server.ShutdownPolicy.HardTimeout = 20 * time.Millisecond
+	_, exitchan := startServer(t, server, nil)
+
+	server.StartRoutine()
+	defer server.FinishRoutine()
+	server.Close()
+
+	select {
+	case err := <-exitchan:
+		var timeout *ShutdownTimeoutError
+		if !errors.As(err, &timeout) {
+			t.Fatalf("Expected a ShutdownTimeoutError, got %v", err)
+		}
+		if timeout.Routines != 1 {
+			t.Errorf("Expected 1 routine left, got %d", timeout.Routines)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return after the hard timeout")
+	}
+}
@@ -0,0 +1,147 @@
+package manners
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// A DrainPriority ranks requests for draining: when the ShutdownPolicy has a
+// DrainTimeout, the contexts of lower priority requests are cancelled first
+// so that higher priority ones get the rest of the budget.
+//
+// The priorities in use, DrainNormal always among them, split the
+// DrainTimeout evenly: with three of them, the lowest is cancelled after a
+// third of the timeout, the next after two thirds, and the highest only when
+// the policy gives up on draining altogether.
+type DrainPriority int
+
+const (
+	DrainLow      DrainPriority = -1 // analytics beacons, prefetches...
+	DrainNormal   DrainPriority = 0  // requests with no priority set.
+	DrainCritical DrainPriority = 1  // payment captures...
+)
+
+// SetDrainPriority sets the drain priority of the requests whose path
+// starts with prefix. The longest matching prefix wins.
+func (s *GracefulServer) SetDrainPriority(prefix string, p DrainPriority) {
+	s.lcsmu.Lock()
+	defer s.lcsmu.Unlock()
+	if s.priorities == nil {
+		s.priorities = make(map[string]DrainPriority)
+	}
+	s.priorities[prefix] = p
+}
+
+// A drainClass holds the requests of one DrainPriority.
+type drainClass struct {
+	inFlight int64 // accessed atomically.
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+// drainClass returns the class of the requests of priority p. It must be
+// called with lcsmu held.
+func (s *GracefulServer) drainClass(p DrainPriority) *drainClass {
+	c, ok := s.classes[p]
+	if !ok {
+		c = new(drainClass)
+		c.ctx, c.cancel = context.WithCancel(context.Background())
+		if s.classes == nil {
+			s.classes = make(map[DrainPriority]*drainClass)
+		}
+		s.classes[p] = c
+	}
+	return c
+}
+
+// enterDrainClass counts r as in flight in the class of its priority and
+// returns it with a context cancelled along with that class. The returned
+// function must be called once the request has been handled. Requests are
+// left alone while no priority is set, since a single class is never
+// cancelled ahead of the others.
+func (s *GracefulServer) enterDrainClass(r *http.Request) (*http.Request, func()) {
+	s.lcsmu.RLock()
+	if len(s.priorities) == 0 {
+		s.lcsmu.RUnlock()
+		return r, func() {}
+	}
+	p, _ := longestPrefix(s.priorities, r.URL.Path)
+	c, ok := s.classes[p]
+	s.lcsmu.RUnlock()
+	if !ok {
+		s.lcsmu.Lock()
+		c = s.drainClass(p)
+		s.lcsmu.Unlock()
+	}
+
+	atomic.AddInt64(&c.inFlight, 1)
+	ctx, cancel := context.WithCancel(r.Context())
+	stop := context.AfterFunc(c.ctx, cancel)
+	return r.WithContext(ctx), func() {
+		stop()
+		cancel()
+		atomic.AddInt64(&c.inFlight, -1)
+	}
+}
+
+// cancelByPriority schedules the cancellation of every drain class but the
+// highest over the DrainTimeout, and returns a function that stops the
+// cancellations not done yet.
+func (s *GracefulServer) cancelByPriority() (stop func()) {
+	timeout := s.ShutdownPolicy.DrainTimeout
+
+	s.lcsmu.Lock()
+	s.drainClass(DrainNormal)
+	for _, p := range s.priorities {
+		s.drainClass(p)
+	}
+	priorities := make([]DrainPriority, 0, len(s.classes))
+	for p := range s.classes {
+		priorities = append(priorities, p)
+	}
+	s.lcsmu.Unlock()
+
+	if timeout <= 0 || len(priorities) < 2 {
+		return func() {}
+	}
+	sort.Slice(priorities, func(i, j int) bool { return priorities[i] < priorities[j] })
+	timers := make([]*time.Timer, len(priorities)-1)
+	for i, p := range priorities[:len(priorities)-1] {
+		p := p
+		after := timeout * time.Duration(i+1) / time.Duration(len(priorities))
+		timers[i] = time.AfterFunc(after, func() { s.cancelDrainClass(p) })
+	}
+	return func() {
+		for _, t := range timers {
+			t.Stop()
+		}
+	}
+}
+
+// cancelDrainClass cancels the contexts of the requests of priority p.
+func (s *GracefulServer) cancelDrainClass(p DrainPriority) {
+	s.lcsmu.Lock()
+	c := s.drainClass(p)
+	s.lcsmu.Unlock()
+	s.shutdownEvent("cancel priority", map[string]int64{
+		"priority":  int64(p),
+		"in_flight": atomic.LoadInt64(&c.inFlight),
+	})
+	c.cancel()
+}
+
+// longestPrefix returns the value of the longest key of m that path starts
+// with.
+func longestPrefix[T any](m map[string]T, path string) (v T, ok bool) {
+	longest := -1
+	for prefix, pv := range m {
+		if len(prefix) > longest && strings.HasPrefix(path, prefix) {
+			v, ok, longest = pv, true, len(prefix)
+		}
+	}
+	return v, ok
+}
@@ -0,0 +1,90 @@
+package manners
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Tests that low priority requests are cancelled during the drain while
+// critical ones keep running.
+func TestDrainPriority(t *testing.T) {
+	server := NewServer()
+	server.ShutdownPolicy.DrainTimeout = 300 * time.Millisecond
+	server.SetDrainPriority("/beacon", DrainLow)
+	server.SetDrainPriority("/pay", DrainCritical)
+	addr, exitchan := startServer(t, server, nil)
+
+	release := make(chan struct{})
+	cancelled := make(chan string, 2)
+	server.SwapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			cancelled <- r.URL.Path
+		case <-release:
+		}
+	}))
+
+	responses := make(chan int, 2)
+	for _, path := range []string{"/beacon", "/pay"} {
+		go func(path string) {
+			resp, err := http.Get("http://" + addr.String() + path)
+			if err != nil {
+				responses <- 0
+				return
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			responses <- resp.StatusCode
+		}(path)
+	}
+	for i := 0; server.Stats().InFlight < 2; i++ {
+		if i == 100 {
+			t.Fatal("requests did not start")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	st := server.Stats()
+	if st.InFlightByPriority[DrainLow] != 1 || st.InFlightByPriority[DrainCritical] != 1 {
+		t.Errorf("Expected one request in flight in each class, got %v", st.InFlightByPriority)
+	}
+
+	server.Close()
+	select {
+	case path := <-cancelled:
+		if path != "/beacon" {
+			t.Errorf("Expected the beacon to be cancelled first, got %s", path)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("low priority request was not cancelled")
+	}
+	select {
+	case path := <-cancelled:
+		t.Errorf("Expected %s to keep running", path)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	<-responses
+	<-responses
+	if err := <-exitchan; err != nil {
+		t.Error("Unexpected error during shutdown", err)
+	}
+}
+
+// Tests that requests are not counted per class while no priority is set.
+func TestDrainPriorityUnset(t *testing.T) {
+	server := NewServer()
+	r, _ := http.NewRequest("GET", "/", nil)
+	allocs := testing.AllocsPerRun(100, func() {
+		_, leave := server.enterDrainClass(r)
+		leave()
+	})
+	if allocs != 0 {
+		t.Errorf("Expected no allocations without priorities, got %v", allocs)
+	}
+	if st := server.Stats(); len(st.InFlightByPriority) != 0 {
+		t.Errorf("Expected no drain classes, got %v", st.InFlightByPriority)
+	}
+}
@@ -0,0 +1,255 @@
+package manners
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrProxyHeader is returned when reading from a connection whose PROXY
+// protocol header is missing or malformed.
+var ErrProxyHeader = errors.New("manners: invalid PROXY protocol header")
+
+// proxyV2Signature starts every PROXY protocol version 2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// A ProxyListener accepts connections from load balancers speaking the PROXY
+// protocol, version 1 or 2, and reports the address of the client the load
+// balancer forwarded as each connection's RemoteAddr. Wrap a listener with
+// NewProxyListener and pass it to GracefulServer.Serve.
+//
+// Only connections from trusted peers are expected to start with a header;
+// a trusted connection without a valid one is dropped. Connections from
+// other peers are passed through untouched, so they cannot spoof their
+// address.
+//
+// The header is read on the connection's first Read or RemoteAddr call, not
+// in Accept, so that a slow peer cannot hold up the server's accept loop.
+type ProxyListener struct {
+	net.Listener
+
+	// ReadTimeout bounds how long reading the header may take. Zero means
+	// no limit.
+	ReadTimeout time.Duration
+
+	trusted []*net.IPNet
+}
+
+// NewProxyListener wraps l, trusting the peers whose addresses are in one of
+// the trusted CIDR ranges, such as "10.0.0.0/8", to send a PROXY protocol
+// header.
+func NewProxyListener(l net.Listener, trusted ...string) (*ProxyListener, error) {
+	pl := &ProxyListener{Listener: l, ReadTimeout: 5 * time.Second}
+	for _, cidr := range trusted {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		pl.trusted = append(pl.trusted, ipnet)
+	}
+	return pl, nil
+}
+
+// Accept waits for the next connection, wrapping it to read its header if
+// it comes from a trusted peer.
+func (l *ProxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.trusts(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &proxyConn{Conn: conn, r: bufio.NewReader(conn), timeout: l.ReadTimeout}, nil
+}
+
+func (l *ProxyListener) trusts(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, ipnet := range l.trusted {
+		if ipnet.Contains(tcp.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// A proxyConn is a connection from a trusted peer, starting with a PROXY
+// protocol header.
+type proxyConn struct {
+	net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+
+	once       sync.Once
+	read       int32 // set atomically once the header has been read.
+	err        error
+	remoteAddr net.Addr
+	localAddr  net.Addr
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+// RemoteAddr returns the address of the client the load balancer
+// forwarded, or of the load balancer itself if the header did not give one.
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the address the client connected to, or the local end
+// of the connection if the header did not give one.
+func (c *proxyConn) LocalAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.localAddr != nil {
+		return c.localAddr
+	}
+	return c.Conn.LocalAddr()
+}
+
+// peekRemoteAddr is RemoteAddr without waiting for the header, for the
+// ConnState hook which runs in the server's accept loop: until the header
+// has been read, it returns the load balancer's address.
+func (c *proxyConn) peekRemoteAddr() net.Addr {
+	if atomic.LoadInt32(&c.read) == 1 && c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) readHeader() {
+	defer atomic.StoreInt32(&c.read, 1)
+	if c.timeout > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+	}
+	start, err := c.r.Peek(len(proxyV2Signature))
+	switch {
+	case err != nil:
+		c.err = err
+	case bytes.Equal(start, proxyV2Signature):
+		c.err = c.readV2()
+	case bytes.HasPrefix(start, []byte("PROXY ")):
+		c.err = c.readV1()
+	default:
+		c.err = ErrProxyHeader
+	}
+	if c.err != nil {
+		// Drop the connection rather than answer a peer that does not
+		// speak the protocol.
+		c.Conn.Close()
+	}
+}
+
+// readV1 reads a header such as "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func (c *proxyConn) readV1() error {
+	var line []byte
+	for len(line) < 107 {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return ErrProxyHeader
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return ErrProxyHeader
+	}
+	src, err := parseTCPAddr(fields[2], fields[4])
+	if err != nil {
+		return err
+	}
+	dst, err := parseTCPAddr(fields[3], fields[5])
+	if err != nil {
+		return err
+	}
+	c.remoteAddr, c.localAddr = src, dst
+	return nil
+}
+
+func parseTCPAddr(ip, port string) (*net.TCPAddr, error) {
+	addr := &net.TCPAddr{IP: net.ParseIP(ip)}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if addr.IP == nil || err != nil {
+		return nil, ErrProxyHeader
+	}
+	addr.Port = int(p)
+	return addr, nil
+}
+
+// readV2 reads a binary header: the signature, the version and command,
+// the address family and protocol, the length of the rest, then the
+// addresses followed by optional TLVs, which are skipped.
+func (c *proxyConn) readV2() error {
+	var hdr [16]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		return err
+	}
+	if hdr[12]>>4 != 2 {
+		return ErrProxyHeader
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return err
+	}
+
+	switch hdr[12] & 0xf {
+	case 0: // LOCAL: a health check from the load balancer itself.
+		return nil
+	case 1: // PROXY
+	default:
+		return ErrProxyHeader
+	}
+
+	var ipLen int
+	switch hdr[13] >> 4 {
+	case 1: // AF_INET
+		ipLen = net.IPv4len
+	case 2: // AF_INET6
+		ipLen = net.IPv6len
+	default: // AF_UNSPEC, AF_UNIX: no usable address.
+		return nil
+	}
+	if len(body) < 2*ipLen+4 {
+		return fmt.Errorf("%w: address block too short", ErrProxyHeader)
+	}
+	ports := body[2*ipLen:]
+	c.remoteAddr = &net.TCPAddr{
+		IP:   net.IP(append([]byte(nil), body[:ipLen]...)),
+		Port: int(binary.BigEndian.Uint16(ports)),
+	}
+	c.localAddr = &net.TCPAddr{
+		IP:   net.IP(append([]byte(nil), body[ipLen:2*ipLen]...)),
+		Port: int(binary.BigEndian.Uint16(ports[2:])),
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package manners
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// startProxyServer serves a handler echoing the request's RemoteAddr on a
+// ProxyListener trusting the given ranges.
+func startProxyServer(t *testing.T, trusted ...string) (*GracefulServer, net.Addr, chan error) {
+	server := NewServer()
+	addr, exitchan := startGenericServer(t, server, nil, func() error {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		pl, err := NewProxyListener(l, trusted...)
+		if err != nil {
+			return err
+		}
+		pl.ReadTimeout = 100 * time.Millisecond
+		return server.Serve(pl)
+	})
+	server.SwapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.RemoteAddr)
+	}))
+	return server, addr, exitchan
+}
+
+// proxyRequest sends header followed by a request on a new connection and
+// returns the response body, or the error reading the response.
+func proxyRequest(t *testing.T, addr net.Addr, header []byte) (string, error) {
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write(header)
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestProxyProtocolV1(t *testing.T) {
+	server, addr, exitchan := startProxyServer(t, "127.0.0.0/8")
+	body, err := proxyRequest(t, addr, []byte("PROXY TCP4 203.0.113.7 192.0.2.1 4242 443\r\n"))
+	if err != nil || body != "203.0.113.7:4242" {
+		t.Errorf("Expected the client address, got %q, %v", body, err)
+	}
+	server.Close()
+	<-exitchan
+}
+
+func TestProxyProtocolV2(t *testing.T) {
+	server, addr, exitchan := startProxyServer(t, "127.0.0.0/8")
+	header := append([]byte(nil), proxyV2Signature...)
+	header = append(header, 0x21, 0x11, 0, 12+4)
+	header = append(header, 203, 0, 113, 7, 192, 0, 2, 1)
+	header = binary.BigEndian.AppendUint16(header, 4242)
+	header = binary.BigEndian.AppendUint16(header, 443)
+	header = append(header, 0x04, 0, 1, 'x') // a TLV to skip
+	body, err := proxyRequest(t, addr, header)
+	if err != nil || body != "203.0.113.7:4242" {
+		t.Errorf("Expected the client address, got %q, %v", body, err)
+	}
+	server.Close()
+	<-exitchan
+}
+
+// Tests that untrusted peers cannot set their address.
+func TestProxyProtocolUntrusted(t *testing.T) {
+	server, addr, exitchan := startProxyServer(t, "10.0.0.0/8")
+	body, err := proxyRequest(t, addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host, _, _ := net.SplitHostPort(body); host != "127.0.0.1" {
+		t.Errorf("Expected the peer address, got %q", body)
+	}
+	server.Close()
+	<-exitchan
+}
+
+// Tests that trusted connections with a bad or missing header are dropped.
+func TestProxyProtocolInvalid(t *testing.T) {
+	server, addr, exitchan := startProxyServer(t, "127.0.0.0/8")
+	if body, err := proxyRequest(t, addr, []byte("PROXY TCP4 nonsense\r\n")); err == nil {
+		t.Errorf("Expected the connection to be dropped, got %q", body)
+	}
+	if body, err := proxyRequest(t, addr, nil); err == nil {
+		t.Errorf("Expected the connection without a header to be dropped, got %q", body)
+	}
+
+	// A peer that sends nothing is dropped after the read timeout.
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected the silent connection to be closed, got %v", err)
+	}
+
+	server.Close()
+	<-exitchan
+}
+
+// Tests that the events for a PROXY protocol connection wrapped in TLS do not
+// wait for its header, which would stall the server's accept loop.
+func TestProxyProtocolRemoteAddrTLS(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	pc := &proxyConn{Conn: server, r: bufio.NewReader(server)}
+	conn := tls.Server(pc, &tls.Config{})
+
+	addr := make(chan string, 1)
+	go func() {
+		addr <- remoteAddr(conn)
+	}()
+	select {
+	case a := <-addr:
+		if a != server.RemoteAddr().String() {
+			t.Errorf("Expected the peer's own address before the header, got %q", a)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("remoteAddr waited for the PROXY header")
+	}
+}
@@ -0,0 +1,68 @@
+package manners
+
+import (
+	"net"
+	"os"
+)
+
+// Ready returns a channel that is closed once the server is accepting
+// connections, so that callers can wait for it to start instead of sleeping.
+// Once the server has shut down, Ready returns a new channel that is closed
+// when it is served again.
+func (s *GracefulServer) Ready() <-chan struct{} {
+	s.lcsmu.RLock()
+	defer s.lcsmu.RUnlock()
+	return s.ready
+}
+
+// ListenerAddr returns the address the server is listening on, or nil if it
+// has not started. Unlike Addr, it holds the actual port when Addr asks for
+// any free one, as with ":0".
+func (s *GracefulServer) ListenerAddr() net.Addr {
+	s.lcsmu.RLock()
+	defer s.lcsmu.RUnlock()
+	return s.listenerAddr
+}
+
+// markReady records the listener the server accepts connections on and
+// tells everyone waiting on Ready.
+func (s *GracefulServer) markReady(l net.Listener) {
+	s.lcsmu.Lock()
+	s.listenerAddr = l.Addr()
+	select {
+	case <-s.ready:
+		// Already serving on another listener.
+	default:
+		close(s.ready)
+	}
+	s.lcsmu.Unlock()
+	s.notifySystemd("READY=1")
+}
+
+// notifySystemd sends state to systemd if NotifySystemd is set.
+func (s *GracefulServer) notifySystemd(state string) {
+	if !s.NotifySystemd {
+		return
+	}
+	if err := SdNotify(state); err != nil {
+		s.logf("manners: sd_notify %s: %v", state, err)
+	}
+}
+
+// SdNotify sends state, such as "READY=1" or "STOPPING=1", to the service
+// manager through the socket named by the NOTIFY_SOCKET environment
+// variable, as described in sd_notify(3). It does nothing if NOTIFY_SOCKET
+// is not set.
+func SdNotify(state string) error {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
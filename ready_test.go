@@ -0,0 +1,76 @@
+package manners
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Tests that Ready is closed once the server listens, and that ListenerAddr
+// then reports the port picked for ":0".
+func TestReady(t *testing.T) {
+	server := NewServer()
+	server.Addr = "localhost:0"
+	ready := server.Ready()
+
+	exitchan := make(chan error)
+	go func() {
+		exitchan <- server.ListenAndServe()
+	}()
+
+	select {
+	case <-ready:
+	case err := <-exitchan:
+		t.Fatal("Server failed to start", err)
+	}
+	addr := server.ListenerAddr().(*net.TCPAddr)
+	if addr.Port == 0 {
+		t.Errorf("expected the bound port, got %s", addr)
+	}
+
+	server.Close()
+	if err := <-exitchan; err != nil {
+		t.Error("Unexpected error during shutdown", err)
+	}
+	select {
+	case <-server.Ready():
+		t.Error("Ready should not be closed once the server has shut down")
+	default:
+	}
+}
+
+func TestNotifySystemd(t *testing.T) {
+	dir, err := os.MkdirTemp("", "manners-notify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal("Failed to create the notify socket", err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", socket)
+
+	server := NewServer()
+	server.NotifySystemd = true
+	_, exitchan := startServer(t, server, nil)
+	server.Close()
+	<-exitchan
+
+	buf := make([]byte, 64)
+	for _, expected := range []string{"READY=1", "STOPPING=1"} {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("expected %s, got error %s", expected, err)
+		}
+		if got := string(buf[:n]); got != expected {
+			t.Errorf("expected %s, got %s", expected, got)
+		}
+	}
+}
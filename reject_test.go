@@ -0,0 +1,89 @@
+package manners
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Tests that requests reaching a closed handler get a 503 with a retry hint
+// and are counted as rejected.
+func TestRejectDuringShutdown(t *testing.T) {
+	server := NewServer()
+	server.ShutdownRetryAfter = 5 * time.Second
+	gh := newGracefulHandler(nullHandler, &server.counters)
+	gh.rejectClosed = server.rejectShutdown
+	gh.Close()
+
+	w := httptest.NewRecorder()
+	gh.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") != "5" || w.Header().Get("Connection") != "close" {
+		t.Errorf("Expected Retry-After and Connection: close, got %v", w.Header())
+	}
+	if server.Stats().Rejected != 1 {
+		t.Errorf("Expected 1 rejected request, got %d", server.Stats().Rejected)
+	}
+}
+
+// Tests that a custom ShutdownHandler answers requests during shutdown.
+func TestShutdownHandler(t *testing.T) {
+	server := NewServer()
+	server.ShutdownHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	gh := newGracefulHandler(nullHandler, &server.counters)
+	gh.rejectClosed = server.rejectShutdown
+	gh.Close()
+
+	w := httptest.NewRecorder()
+	gh.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusTeapot {
+		t.Errorf("Expected the custom handler's status, got %d", w.Code)
+	}
+	if server.Stats().Rejected != 1 {
+		t.Errorf("Expected 1 rejected request, got %d", server.Stats().Rejected)
+	}
+}
+
+// Tests that a request sent over a real connection opened before Close is
+// answered with a 503, a retry hint and "Connection: close".
+func TestRejectDuringShutdownEndToEnd(t *testing.T) {
+	server := NewServer()
+	server.ShutdownRetryAfter = 2 * time.Second
+	statechanged := make(chan http.ConnState, 100)
+	addr, exitchan := startServer(t, server, statechanged)
+
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitForState(t, statechanged, http.StateNew, "Client failed to connect")
+
+	server.Close()
+	waitForPhase(t, server, PhaseShuttingDown)
+
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: test\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "2" || !resp.Close {
+		t.Errorf("Expected 503 with Retry-After and Connection: close, got %d %v", resp.StatusCode, resp.Header)
+	}
+
+	if err := <-exitchan; err != nil {
+		t.Error("Unexpected error during shutdown", err)
+	}
+	if n := server.Stats().Rejected; n != 1 {
+		t.Errorf("Expected 1 rejected request, got %d", n)
+	}
+}
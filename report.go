@@ -0,0 +1,77 @@
+package manners
+
+import "time"
+
+// A ShutdownReport describes how a GracefulServer's shutdown went, so that
+// unclean shutdowns can be logged and alerted on.
+type ShutdownReport struct {
+	// Initiated is set by BlockingClose if its call started the shutdown.
+	Initiated bool
+
+	// Start is when Close was called, End when Serve finished.
+	Start time.Time
+	End   time.Time
+
+	// Timings breaks the shutdown down by ShutdownPolicy phase.
+	Timings ShutdownTimings
+
+	// IdleClosed counts the idle keep-alive connections closed when the
+	// server stopped accepting connections.
+	IdleClosed int
+
+	// Drained counts the connections that were busy when the server stopped
+	// accepting connections and finished on their own.
+	Drained int
+
+	// ForcedClosed counts the connections the server closed itself because
+	// they were still open when the ShutdownPolicy gave up on draining.
+	ForcedClosed int
+
+	// Rejected counts the requests that arrived after shutdown started and
+	// were not passed to the handler.
+	Rejected int
+
+	// OutstandingRoutines is the number of routines still running when Serve
+	// gave up waiting for them. It is zero after a complete drain.
+	OutstandingRoutines int
+
+	// ListenerErrors holds the errors returned while closing the listener.
+	ListenerErrors []error
+
+	// Aborted lists the requests that were still running when the server
+	// started closing connections forcibly, and those cut off by the drain
+	// budget.
+	Aborted []AbortedRequest
+}
+
+// Clean reports whether the shutdown finished without aborting requests,
+// closing connections forcibly, abandoning routines or failing to close the
+// listener.
+func (r ShutdownReport) Clean() bool {
+	return r.ForcedClosed == 0 && r.OutstandingRoutines == 0 &&
+		len(r.ListenerErrors) == 0 && len(r.Aborted) == 0
+}
+
+// ShutdownReport returns the report of the server's last shutdown, once
+// Serve has returned.
+func (s *GracefulServer) ShutdownReport() ShutdownReport {
+	s.lcsmu.RLock()
+	defer s.lcsmu.RUnlock()
+	r := s.report
+	r.ListenerErrors = append([]error(nil), r.ListenerErrors...)
+	r.Aborted = append([]AbortedRequest(nil), r.Aborted...)
+	return r
+}
+
+// finish fills in the counts of the report from the server's counters when
+// the shutdown started and ended, the number of busy connections when the
+// drain started and the routines left behind.
+func (r *ShutdownReport) finish(start, end counters, draining, outstanding int) {
+	r.ForcedClosed = int(end.forcedClosed - start.forcedClosed)
+	r.Rejected = int(end.rejected - start.rejected)
+	r.OutstandingRoutines = outstanding
+	r.Drained = draining - r.ForcedClosed
+	if r.Drained < 0 {
+		r.Drained = 0
+	}
+}
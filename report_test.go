@@ -0,0 +1,70 @@
+package manners
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Tests that BlockingClose reports a clean shutdown of an idle connection.
+func TestShutdownReportClean(t *testing.T) {
+	server := NewServer()
+	statechanged := make(chan http.ConnState, 100)
+	addr, exitchan := startServer(t, server, statechanged)
+
+	client := newClient(addr, false)
+	client.Run()
+	<-client.connected
+	client.sendrequest <- true
+	<-client.response
+	waitForState(t, statechanged, http.StateIdle, "Client failed to reach idle state")
+
+	report := server.BlockingClose()
+	if err := <-exitchan; err != nil {
+		t.Error("Unexpected error during shutdown", err)
+	}
+	if !report.Initiated || !report.Clean() {
+		t.Errorf("expected a clean shutdown started by BlockingClose, got %+v", report)
+	}
+	if report.IdleClosed != 1 {
+		t.Errorf("expected 1 idle connection closed, got %d", report.IdleClosed)
+	}
+	if !report.End.After(report.Start) {
+		t.Errorf("expected the shutdown to end after it started, got %s - %s", report.Start, report.End)
+	}
+	if server.BlockingClose().Initiated {
+		t.Error("second call to BlockingClose reported initiating the shutdown")
+	}
+
+	close(client.sendrequest)
+	<-client.closed
+}
+
+// Tests that a shutdown that gives up on draining is reported as unclean.
+func TestShutdownReportForced(t *testing.T) {
+	server := NewServer()
+	server.ShutdownPolicy.DrainTimeout = 20 * time.Millisecond
+	server.ShutdownPolicy.HardTimeout = 20 * time.Millisecond
+	statechanged := make(chan http.ConnState, 100)
+	addr, exitchan := startServer(t, server, statechanged)
+
+	client := newClient(addr, false)
+	client.Run()
+	<-client.connected
+	waitForState(t, statechanged, http.StateNew, "Request not received")
+
+	server.StartRoutine()
+	defer server.FinishRoutine()
+	report := server.BlockingClose()
+	<-exitchan
+
+	if report.Clean() {
+		t.Error("expected an unclean shutdown")
+	}
+	if report.ForcedClosed != 1 || report.OutstandingRoutines != 1 {
+		t.Errorf("expected 1 forced close and 1 outstanding routine, got %+v", report)
+	}
+
+	close(client.sendrequest)
+	<-client.closed
+}
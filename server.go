@@ -41,11 +41,17 @@ The server will shut down cleanly when the Close() method is called:
 package manners
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
+	"log"
 	"net"
 	"net/http"
+	"runtime/debug"
+	"strconv"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // A GracefulServer maintains a WaitGroup that counts how many in-flight
@@ -60,15 +66,116 @@ import (
 type GracefulServer struct {
 	*http.Server
 
-	shutdown         chan bool
-	shutdownFinished chan bool
-	wg               waitGroup
-	routinesCount    int
-
-	lcsmu       sync.RWMutex
-	connections map[net.Conn]bool
-
-	up chan net.Listener // Only used by test code.
+	// MaxRequestsPerConn limits the number of requests a single keep-alive
+	// connection may serve. The response to the last allowed request is sent
+	// with "Connection: close". Zero means no limit.
+	MaxRequestsPerConn int
+
+	// Name, if set, publishes the server's statistics through expvar under
+	// this name the first time Serve is called, so that they appear in
+	// /debug/vars.
+	Name string
+
+	// Events, if set, receives an Event for every connection state change
+	// and every step of the server's shutdown.
+	Events EventSink
+
+	// Tracer, if set, traces each step of the server's shutdown.
+	Tracer Tracer
+
+	// NotifySystemd makes the server tell systemd when it is ready and when it
+	// starts shutting down, as required by services of Type=notify. See
+	// SdNotify.
+	NotifySystemd bool
+
+	// ShutdownPolicy controls how long the server keeps serving and draining
+	// once Close is called, and when it gives up on draining. The zero value
+	// waits for every connection and routine to finish.
+	ShutdownPolicy ShutdownPolicy
+
+	// RejectWhilePaused makes the server answer requests on already open
+	// keep-alive connections with 503 Service Unavailable, and close those
+	// connections, while it is paused.
+	RejectWhilePaused bool
+
+	// RecoverPanics makes the server recover panics in its handler, answer
+	// the request with 500 Internal Server Error and report the panic to
+	// PanicReporter, or log it through the Server's ErrorLog if that is not
+	// set. Whether or not panics are recovered, the routines a panicking
+	// handler started with StartRequestRoutine are released.
+	RecoverPanics bool
+	PanicReporter PanicReporter
+
+	// RequestTimeout, if positive, limits how long the handler may take to
+	// answer a request: past it, the request's context is cancelled and the
+	// client gets 503 Service Unavailable instead of whatever the handler
+	// writes. The response is buffered until the handler returns, as with
+	// http.TimeoutHandler. SetRequestTimeout overrides it for a path prefix.
+	// If the ShutdownPolicy has a DrainTimeout, the requests with a timeout
+	// still running when the drain budget, PreStopDelay plus DrainTimeout
+	// from the call to Close, runs out are timed out as well, shortly before
+	// the connections left are closed, and reported as aborted. Either way,
+	// the server keeps counting a timed out request as in flight until its
+	// handler returns.
+	RequestTimeout time.Duration
+
+	// Requests that reach the handler once shutdown has started, on
+	// connections that were kept alive, are answered with 503 Service
+	// Unavailable and "Connection: close", with a Retry-After header if
+	// ShutdownRetryAfter is positive. ShutdownHandler, if set, answers them
+	// instead. Either way they are counted as rejected in Stats.
+	ShutdownRetryAfter time.Duration
+	ShutdownHandler    http.Handler
+
+	// RequestIDHeader names the request header holding the request ID
+	// reported for the requests aborted by a forced shutdown. Zero means
+	// "X-Request-Id".
+	RequestIDHeader string
+
+	// AbortedRequestsFile, if set, is the file the requests aborted by a
+	// forced shutdown are appended to, one JSON object per line, in addition
+	// to being listed in the ShutdownReport.
+	AbortedRequestsFile string
+
+	wg            waitGroup
+	routinesCount int
+
+	lcsmu        sync.RWMutex
+	closing      chan struct{} // closed by Close.
+	closed       bool
+	done         chan struct{} // closed when Serve has finished shutting down.
+	handler      *gracefulHandler
+	hooked       bool
+	resumed      chan struct{} // non-nil while paused, closed by Resume.
+	ready        chan struct{} // closed once Serve is accepting connections.
+	listenerAddr net.Addr
+	serving      int // Serve calls running.
+	shutdownOnce *sync.Once
+	report       ShutdownReport
+	reportBase   counters // counters when the shutdown started.
+	draining     int      // busy connections when the drain started.
+	cancels      map[net.Listener]context.CancelFunc
+	connections  map[net.Conn]trackedConn
+	listeners    map[string]*uint64
+	shutdownCtx  context.Context
+	shutdownSpan Span
+
+	routinesCtx    context.Context // passed to routines started with Go.
+	cancelRoutines context.CancelFunc
+	budgetCtx      context.Context // cancelled when the drain budget runs out.
+	cancelBudget   context.CancelFunc
+	tasks          []*periodicTask
+	scheduling     bool                     // tasks are scheduled while Serve runs.
+	timeouts       map[string]time.Duration // by path prefix.
+	priorities     map[string]DrainPriority // by path prefix.
+	classes        map[DrainPriority]*drainClass
+	requestsmu     sync.Mutex
+	requests       map[*AbortedRequest]struct{} // in flight, guarded by requestsmu.
+
+	phase      int32  // a Phase, accessed atomically.
+	paused     int32  // accessed atomically.
+	lastConnID uint64 // accessed atomically.
+	counters   counters
 }
 
 // NewServer creates a new GracefulServer.
@@ -79,28 +186,70 @@ func NewServer() *GracefulServer {
 // NewWithServer wraps an existing http.Server object and returns a
 // GracefulServer that supports all of the original Server operations.
 func NewWithServer(s *http.Server) *GracefulServer {
-	return &GracefulServer{
-		Server:           s,
-		shutdown:         make(chan bool),
-		shutdownFinished: make(chan bool, 1),
-		wg:               new(sync.WaitGroup),
-		routinesCount:    0,
-		connections:      make(map[net.Conn]bool),
+	gs := &GracefulServer{
+		Server:        s,
+		closing:       make(chan struct{}),
+		done:          make(chan struct{}),
+		ready:         make(chan struct{}),
+		shutdownOnce:  new(sync.Once),
+		wg:            new(sync.WaitGroup),
+		routinesCount: 0,
+		connections:   make(map[net.Conn]trackedConn),
+		listeners:     make(map[string]*uint64),
+		cancels:       make(map[net.Listener]context.CancelFunc),
 	}
+	gs.resetRoutinesContext()
+	gs.resetBudget()
+	return gs
+}
+
+// trackedConn is what the server knows about a connection it has seen in
+// ConnState.
+type trackedConn struct {
+	id        uint64
+	state     http.ConnState
+	protected bool // counted in the WaitGroup
+	requests  int  // requests started on this connection
 }
 
+type contextKey int
+
+// connContextKey is the request context key under which Serve stores the
+// connection a request arrived on.
+const connContextKey contextKey = 0
+
+// serverContextKey is the request context key under which Serve stores the
+// server a request is served by.
+const serverContextKey contextKey = 2
+
 // Close stops the server from accepting new requets and begins shutting down.
-// It returns true if it's the first time Close is called.
+// It returns true if it's the first time Close is called since the server
+// was last served. Closing a server that has not started serving yet makes
+// it shut down as soon as it starts; closing a server that has already shut
+// down does nothing.
 func (s *GracefulServer) Close() bool {
-	return <-s.shutdown
+	s.lcsmu.Lock()
+	defer s.lcsmu.Unlock()
+	if s.closed || s.Phase() == PhaseStopped {
+		return false
+	}
+	s.closed = true
+	close(s.closing)
+	return true
 }
 
-// BlockingClose is similar to Close, except that it blocks until the last
-// connection has been closed.
-func (s *GracefulServer) BlockingClose() bool {
-	result := s.Close()
-	<-s.shutdownFinished
-	return result
+// BlockingClose is similar to Close, except that it blocks until the server
+// has finished shutting down, and then returns a report of the shutdown.
+// The report's Initiated field is what Close would have returned.
+func (s *GracefulServer) BlockingClose() ShutdownReport {
+	s.lcsmu.RLock()
+	done := s.done
+	s.lcsmu.RUnlock()
+	initiated := s.Close()
+	<-done
+	report := s.ShutdownReport()
+	report.Initiated = initiated
+	return report
 }
 
 // ListenAndServe provides a graceful equivalent of net/http.Serve.ListenAndServe.
@@ -148,22 +297,283 @@ func (s *GracefulServer) ListenAndServeTLS(certFile, keyFile string) error {
 }
 
 // Serve provides a graceful equivalent net/http.Server.Serve.
+//
+// A server can be served again after it has shut down. Serve wraps the
+// Server's Handler, BaseContext, ConnState and ConnContext the first time it
+// is called; later calls reuse those wrappers, so BaseContext, ConnState and
+// ConnContext should be set before the first call. Keep-alives, disabled
+// during the previous shutdown, are enabled again.
+//
+// Serve returns nil after a clean shutdown, and ErrServerClosed at once if
+// the server is still shutting down. Otherwise the returned error joins the
+// error that stopped the underlying server, if it was not closed by Close, a
+// ListenerCloseError for each error closing the listener and a
+// ShutdownTimeoutError if the ShutdownPolicy gave up draining. The latter two
+// are also logged through the Server's ErrorLog as they happen.
 func (s *GracefulServer) Serve(listener net.Listener) error {
-	// Wrap the server HTTP handler into graceful one, that will close kept
-	// alive connections if a new request is received after shutdown.
-	gracefulHandler := newGracefulHandler(s.Server.Handler)
-	s.Server.Handler = gracefulHandler
+	s.publishExpvar()
+	if err := s.start(); err != nil {
+		return err
+	}
+	listener = s.gatePaused(s.countAccepts(listener))
+
+	s.lcsmu.Lock()
+	closing, done := s.closing, s.done
+	shutdownOnce := s.shutdownOnce
+	gracefulHandler := s.handler
+	s.lcsmu.Unlock()
 
 	// Start a goroutine that waits for a shutdown signal and will stop the
 	// listener when it receives the signal. That in turn will result in
-	// unblocking of the http.Serve call.
+	// unblocking of the http.Serve call. When the server is served on
+	// several listeners, the first of these goroutines shuts the server
+	// down while the others wait for it, then each closes its listener.
+	served := make(chan struct{})
+	listenerClosed := make(chan struct{})
 	go func() {
-		s.shutdown <- true
-		close(s.shutdown)
-		gracefulHandler.Close()
-		s.Server.SetKeepAlivesEnabled(false)
-		listener.Close()
+		select {
+		case <-closing:
+		case <-served:
+			return
+		}
+		shutdownOnce.Do(func() {
+			s.beginShutdown(gracefulHandler)
+		})
+		s.traceStep("close listener", func() {
+			if err := listener.Close(); err != nil {
+				s.logf("manners: closing listener: %v", err)
+				s.lcsmu.Lock()
+				s.report.ListenerErrors = append(s.report.ListenerErrors, err)
+				s.lcsmu.Unlock()
+			}
+		})
+		close(listenerClosed)
+	}()
+
+	s.markReady(listener)
+
+	err := s.Server.Serve(listener)
+	close(served)
+	if gracefulHandler.IsClosed() {
+		// The listener being closed by the shutdown is not worth reporting,
+		// but any other error is.
+		if errors.Is(err, net.ErrClosed) {
+			err = nil
+		}
+		<-listenerClosed
+	}
+	drainStart := time.Now()
+
+	// Wait for pending requests to complete regardless the Serve result, for
+	// as long as the shutdown policy allows.
+	s.shutdownEvent("waiting on routines", map[string]int64{"routines": int64(s.RoutinesCount())})
+	routinesDone := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(routinesDone)
 	}()
+	stopCancelling := s.cancelByPriority()
+	drained := s.awaitRoutines("wait for routines", routinesDone, s.ShutdownPolicy.DrainTimeout)
+	stopCancelling()
+	if !drained && s.limitsRequests() {
+		// Give the requests the drain budget has just cut off a moment to
+		// send their 503 before closing their connections.
+		drained = s.awaitRoutines("wait for timed out requests", routinesDone, timedOutGrace)
+	}
+	s.lcsmu.Lock()
+	s.report.Timings.Drain = time.Since(drainStart)
+	s.lcsmu.Unlock()
+	if !drained {
+		hardStart := time.Now()
+		// Force the connections closed unless another Serve call already
+		// has.
+		for p := s.Phase(); p != PhaseForcing; p = s.Phase() {
+			if s.swapPhase(p, PhaseForcing) {
+				s.traceStep("forced close", s.forceClose)
+				break
+			}
+		}
+		drained = s.awaitRoutines("wait for routines after forced close", routinesDone, s.ShutdownPolicy.HardTimeout)
+		s.lcsmu.Lock()
+		s.report.Timings.Hard = time.Since(hardStart)
+		s.lcsmu.Unlock()
+	}
+	outstanding := 0
+	var timeoutErr error
+	if !drained {
+		outstanding = s.RoutinesCount()
+		timeoutErr = s.shutdownTimeoutError()
+		s.logf("%v", timeoutErr)
+	}
+	s.cancelRequests(listener)
+
+	// Only the last Serve call to return finishes the shutdown.
+	s.lcsmu.Lock()
+	s.serving--
+	if s.serving > 0 {
+		s.lcsmu.Unlock()
+		return errors.Join(err, timeoutErr)
+	}
+	s.report.End = time.Now()
+	s.report.finish(s.reportBase, s.counters.snapshot(), s.draining, outstanding)
+	errs := []error{err}
+	for _, lerr := range s.report.ListenerErrors {
+		errs = append(errs, &ListenerCloseError{Err: lerr})
+	}
+	errs = append(errs, timeoutErr)
+	aborted := s.report.Aborted
+	s.ready = make(chan struct{})
+	s.scheduling = false
+	if s.shutdownSpan != nil {
+		s.shutdownSpan.AddEvent("finished", nil)
+		s.shutdownSpan.End()
+	}
+	s.lcsmu.Unlock()
+	if err := s.persistAborted(aborted); err != nil {
+		s.logf("%v", err)
+		errs = append(errs, err)
+	}
+	if drained {
+		s.emit(Event{Kind: EventDrained})
+	}
+	s.setPhase(PhaseStopped)
+	close(done)
+	return errors.Join(errs...)
+}
+
+// beginShutdown runs the steps of the shutdown that concern the whole server
+// rather than one listener: it waits for the pre-stop delay, then stops
+// handling new requests and closes the idle connections.
+func (s *GracefulServer) beginShutdown(gh *gracefulHandler) {
+	ctx, span := s.tracer().Start(context.Background(), "manners.shutdown")
+	span.AddEvent("signal received", nil)
+	s.lcsmu.Lock()
+	s.report.Start = time.Now()
+	s.reportBase = s.counters.snapshot()
+	s.shutdownCtx, s.shutdownSpan = ctx, span
+	s.lcsmu.Unlock()
+	s.startBudget()
+	s.notifySystemd("STOPPING=1")
+	if delay := s.ShutdownPolicy.PreStopDelay; delay > 0 {
+		s.setPhase(PhasePreStop)
+		s.traceStep("pre-stop delay", func() {
+			time.Sleep(delay)
+		})
+	}
+	s.setPhase(PhaseShuttingDown)
+	gh.Close()
+	s.traceStep("disable keep-alives", func() {
+		s.Server.SetKeepAlivesEnabled(false)
+	})
+	s.traceStep("close idle connections", func() {
+		idle := s.closeIdleConns()
+		s.lcsmu.Lock()
+		s.report.IdleClosed = idle
+		s.lcsmu.Unlock()
+	})
+
+	s.lcsmu.Lock()
+	s.report.Timings.PreStop = time.Since(s.report.Start)
+	s.draining = 0
+	for _, tc := range s.connections {
+		if tc.state != http.StateIdle {
+			s.draining++
+		}
+	}
+	s.lcsmu.Unlock()
+}
+
+// start prepares the server for a call to Serve: it installs the server's
+// hooks into the http.Server and, if the server has been served and shut
+// down before, resets the state left behind by that shutdown. It returns
+// ErrServerClosed if the server is still shutting down.
+func (s *GracefulServer) start() error {
+	s.lcsmu.Lock()
+	switch s.Phase() {
+	case PhasePreStop, PhaseShuttingDown, PhaseForcing:
+		s.lcsmu.Unlock()
+		return ErrServerClosed
+	case PhaseStopped:
+		s.closing = make(chan struct{})
+		s.closed = false
+		s.done = make(chan struct{})
+		s.report = ShutdownReport{}
+		s.shutdownOnce = new(sync.Once)
+		s.shutdownCtx, s.shutdownSpan = nil, nil
+		s.resetRoutinesContext()
+		s.resetBudget()
+		s.classes = nil
+		s.Server.SetKeepAlivesEnabled(true)
+	}
+
+	// Wrap the server HTTP handler into graceful one, that will reject, and
+	// close the connection of, any new request received after shutdown. The
+	// handler is wrapped again only if it was replaced since the last run.
+	if gh, ok := s.Server.Handler.(*gracefulHandler); !ok || gh != s.handler {
+		s.handler = newGracefulHandler(s.Server.Handler, &s.counters)
+		s.handler.lastRequest = s.lastRequestOnConn
+		s.handler.rejectPaused = s.rejectingPaused
+		s.handler.releaseRoutines = s.releaseRoutines
+		s.handler.recoverPanics = s.recoveringPanics
+		s.handler.panicked = s.handlerPanicked
+		s.handler.limit = s.limitRequest
+		s.handler.rejectClosed = s.rejectShutdown
+		s.handler.enterClass = s.enterDrainClass
+		s.handler.track = s.trackRequest
+		s.Server.Handler = s.handler
+	}
+	s.handler.Open()
+
+	if !s.hooked {
+		s.hookConnections()
+		s.hooked = true
+	}
+
+	s.serving++
+	if !s.scheduling {
+		s.scheduling = true
+		for _, t := range s.tasks {
+			go s.schedule(t, s.closing, s.done)
+		}
+	}
+	s.lcsmu.Unlock()
+
+	if s.Paused() {
+		s.setPhase(PhasePaused)
+	} else {
+		s.setPhase(PhaseServing)
+	}
+	return nil
+}
+
+// hookConnections wraps the http.Server's BaseContext, ConnContext and
+// ConnState to keep track of the server's connections.
+func (s *GracefulServer) hookConnections() {
+	// Give the requests served on each listener a context that is cancelled
+	// when the shutdown policy gives up on them, and that leads back to the
+	// server.
+	originalBaseContext := s.Server.BaseContext
+	s.Server.BaseContext = func(l net.Listener) context.Context {
+		ctx := context.Background()
+		if originalBaseContext != nil {
+			ctx = originalBaseContext(l)
+		}
+		ctx, cancel := context.WithCancel(ctx)
+		s.lcsmu.Lock()
+		s.cancels[l] = cancel
+		s.lcsmu.Unlock()
+		return context.WithValue(ctx, serverContextKey, s)
+	}
+
+	// Remember the connection each request arrives on, so the handler can
+	// find out how many requests that connection has served.
+	originalConnContext := s.Server.ConnContext
+	s.Server.ConnContext = func(ctx context.Context, conn net.Conn) context.Context {
+		if originalConnContext != nil {
+			ctx = originalConnContext(ctx, conn)
+		}
+		return context.WithValue(ctx, connContextKey, conn)
+	}
 
 	originalConnState := s.Server.ConnState
 
@@ -172,65 +582,121 @@ func (s *GracefulServer) Serve(listener net.Listener) error {
 	// enabling manners to handle persisted connections correctly.
 	s.ConnState = func(conn net.Conn, newState http.ConnState) {
 		s.lcsmu.RLock()
-		protected := s.connections[conn]
+		tc := s.connections[conn]
 		s.lcsmu.RUnlock()
 
 		switch newState {
 
 		case http.StateNew:
 			// New connection -> StateNew
-			protected = true
+			atomic.AddUint64(&s.counters.accepted, 1)
+			tc.id = atomic.AddUint64(&s.lastConnID, 1)
+			tc.protected = true
 			s.StartRoutine()
 
 		case http.StateActive:
 			// (StateNew, StateIdle) -> StateActive
-			if gracefulHandler.IsClosed() {
-				conn.Close()
-				break
-			}
-
-			if !protected {
-				protected = true
+			// Once shutdown has started, the closed gracefulHandler answers
+			// the request with "Connection: close", so the connection is
+			// still counted until that answer has been written.
+			tc.requests++
+			if !tc.protected {
+				tc.protected = true
 				s.StartRoutine()
 			}
 
 		default:
 			// (StateNew, StateActive) -> (StateIdle, StateClosed, StateHiJacked)
-			if protected {
+			if tc.protected {
 				s.FinishRoutine()
-				protected = false
+				tc.protected = false
 			}
 		}
 
+		switch newState {
+		case http.StateClosed:
+			atomic.AddUint64(&s.counters.closed, 1)
+		case http.StateHijacked:
+			atomic.AddUint64(&s.counters.hijacked, 1)
+		}
+
+		tc.state = newState
 		s.lcsmu.Lock()
 		if newState == http.StateClosed || newState == http.StateHijacked {
 			delete(s.connections, conn)
 		} else {
-			s.connections[conn] = protected
+			s.connections[conn] = tc
 		}
 		s.lcsmu.Unlock()
 
+		s.connEvent(conn, tc.id, newState)
+
 		if originalConnState != nil {
 			originalConnState(conn, newState)
 		}
 	}
+}
 
-	// A hook to allow the server to notify others when it is ready to receive
-	// requests; only used by tests.
-	if s.up != nil {
-		s.up <- listener
+// closeIdleConns closes the kept-alive connections that are waiting for a new
+// request, and returns how many it closed.
+func (s *GracefulServer) closeIdleConns() int {
+	var idle []net.Conn
+	s.lcsmu.RLock()
+	for conn, tc := range s.connections {
+		if tc.state == http.StateIdle {
+			idle = append(idle, conn)
+		}
 	}
+	s.lcsmu.RUnlock()
 
-	err := s.Server.Serve(listener)
-	// An error returned on shutdown is not worth reporting.
-	if err != nil && gracefulHandler.IsClosed() {
-		err = nil
+	for _, conn := range idle {
+		conn.Close()
+	}
+	return len(idle)
+}
+
+// logf logs through the server's ErrorLog, or the standard logger if it is
+// not set, like net/http does.
+func (s *GracefulServer) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// lastRequestOnConn reports whether r is the last request the connection it
+// arrived on is allowed to serve under MaxRequestsPerConn.
+func (s *GracefulServer) lastRequestOnConn(r *http.Request) bool {
+	if s.MaxRequestsPerConn <= 0 {
+		return false
+	}
+	conn, ok := r.Context().Value(connContextKey).(net.Conn)
+	if !ok {
+		return false
+	}
+	s.lcsmu.RLock()
+	defer s.lcsmu.RUnlock()
+	return s.connections[conn].requests >= s.MaxRequestsPerConn
+}
+
+// rejectShutdown answers a request that arrived once shutdown had started.
+func (s *GracefulServer) rejectShutdown(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Connection", "close")
+	if s.ShutdownHandler != nil {
+		s.ShutdownHandler.ServeHTTP(w, r)
+		return
 	}
+	setRetryAfter(w, s.ShutdownRetryAfter)
+	http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+}
 
-	// Wait for pending requests to complete regardless the Serve result.
-	s.wg.Wait()
-	s.shutdownFinished <- true
-	return err
+// setRetryAfter sets the Retry-After header to d rounded up to the second,
+// if d is positive.
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	if d > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int((d+time.Second-1)/time.Second)))
+	}
 }
 
 // StartRoutine increments the server's WaitGroup. Use this if a web request
@@ -262,31 +728,121 @@ func (s *GracefulServer) RoutinesCount() int {
 // gracefulHandler is used by GracefulServer to prevent calling ServeHTTP on
 // to be closed kept-alive connections during the server shutdown.
 type gracefulHandler struct {
-	closed  int32 // accessed atomically.
-	wrapped http.Handler
+	closed   int32 // accessed atomically.
+	counters *counters
+
+	mu      sync.RWMutex
+	wrapped *handlerGeneration
+
+	// lastRequest, if set, reports whether the connection should be closed
+	// once the response to the request has been written.
+	lastRequest func(*http.Request) bool
+
+	// rejectPaused, if set, reports whether requests should be answered with
+	// 503 Service Unavailable because the server is paused.
+	rejectPaused func() bool
+
+	// releaseRoutines, if set, finishes the routines a panicking request
+	// left running.
+	releaseRoutines func(n int)
+
+	// recoverPanics, if set, reports whether panics should be recovered and
+	// passed to panicked.
+	recoverPanics func() bool
+	panicked      func(http.ResponseWriter, *http.Request, HandlerPanic)
+
+	// limit, if set, applies the request's timeout and drain budget to the
+	// handler serving it.
+	limit func(*http.Request, http.Handler) http.Handler
+
+	// rejectClosed, if set, answers the requests that arrive once the handler
+	// is closed.
+	rejectClosed func(http.ResponseWriter, *http.Request)
+
+	// enterClass, if set, counts the request in its drain priority class and
+	// returns the function to call once it has been handled.
+	enterClass func(*http.Request) (*http.Request, func())
+
+	// track, if set, records the request as in flight until the returned
+	// function is called.
+	track func(*http.Request) (*http.Request, func())
 }
 
-func newGracefulHandler(wrapped http.Handler) *gracefulHandler {
+func newGracefulHandler(wrapped http.Handler, c *counters) *gracefulHandler {
 	return &gracefulHandler{
-		wrapped: wrapped,
+		wrapped:  newHandlerGeneration(wrapped),
+		counters: c,
 	}
 }
 
 func (gh *gracefulHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if atomic.LoadInt32(&gh.closed) == 0 {
-		gh.wrapped.ServeHTTP(w, r)
+		if gh.rejectPaused != nil && gh.rejectPaused() {
+			rejectPaused(w)
+			return
+		}
+		if gh.lastRequest != nil && gh.lastRequest(r) {
+			w.Header().Set("Connection", "close")
+		}
+		atomic.AddInt64(&gh.counters.inFlight, 1)
+		defer atomic.AddInt64(&gh.counters.inFlight, -1)
+		if gh.track != nil {
+			var untrack func()
+			r, untrack = gh.track(r)
+			defer untrack()
+		}
+		if gh.enterClass != nil {
+			var leave func()
+			r, leave = gh.enterClass(r)
+			defer leave()
+		}
+		r, routines := withRequestRoutines(r)
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			n := routines.release()
+			if n > 0 && gh.releaseRoutines != nil {
+				gh.releaseRoutines(n)
+			}
+			if gh.recoverPanics == nil || !gh.recoverPanics() {
+				return
+			}
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			gh.panicked(w, r, HandlerPanic{
+				PanicError: PanicError{Value: v, Stack: debug.Stack()},
+				Routines:   n,
+			})
+		}()
+		gh.serveWrapped(w, r)
+		completed = true
 		return
 	}
+	atomic.AddUint64(&gh.counters.rejected, 1)
 	r.Body.Close()
 	// Server is shutting down at this moment, and the connection that this
 	// handler is being called on is about to be closed. So we do not need to
-	// actually execute the handler logic.
+	// actually execute the handler logic, only to tell the client to retry.
+	if gh.rejectClosed != nil {
+		gh.rejectClosed(w, r)
+	}
 }
 
 func (gh *gracefulHandler) Close() {
 	atomic.StoreInt32(&gh.closed, 1)
 }
 
+func (gh *gracefulHandler) Open() {
+	atomic.StoreInt32(&gh.closed, 0)
+}
+
 func (gh *gracefulHandler) IsClosed() bool {
 	return atomic.LoadInt32(&gh.closed) == 1
 }
@@ -35,9 +35,9 @@ func TestGracefulness(t *testing.T) {
 	wg := helpers.NewWaitGroup()
 	server.wg = wg
 	statechanged := make(chan http.ConnState)
-	listener, exitchan := startServer(t, server, statechanged)
+	addr, exitchan := startServer(t, server, statechanged)
 
-	client := newClient(listener.Addr(), false)
+	client := newClient(addr, false)
 	client.Run()
 
 	// wait for client to connect, but don't let it send the request yet
@@ -83,9 +83,9 @@ func TestShutdown(t *testing.T) {
 	wg := helpers.NewWaitGroup()
 	server.wg = wg
 	statechanged := make(chan http.ConnState)
-	listener, exitchan := startServer(t, server, statechanged)
+	addr, exitchan := startServer(t, server, statechanged)
 
-	client1 := newClient(listener.Addr(), false)
+	client1 := newClient(addr, false)
 	client1.Run()
 
 	// wait for client1 to connect
@@ -111,7 +111,7 @@ func TestShutdown(t *testing.T) {
 	}
 
 	// should get connection refused at this point
-	client2 := newClient(listener.Addr(), false)
+	client2 := newClient(addr, false)
 	client2.Run()
 
 	if err := <-client2.connected; err == nil {
@@ -124,33 +124,42 @@ func TestShutdown(t *testing.T) {
 	<-exitchan
 }
 
-// If a request is sent to a closed server via a kept alive connection then
-// the server closes the connection upon receiving the request.
+// If a request is sent to a closing server on a connection that was opened
+// before Close, the server answers it with 503 Service Unavailable and
+// closes the connection.
 func TestRequestAfterClose(t *testing.T) {
 	// Given
 	server := NewServer()
 	srvStateChangedCh := make(chan http.ConnState, 100)
-	listener, srvClosedCh := startServer(t, server, srvStateChangedCh)
+	addr, srvClosedCh := startServer(t, server, srvStateChangedCh)
 
-	client := newClient(listener.Addr(), false)
+	client := newClient(addr, false)
 	client.Run()
 	<-client.connected
-	client.sendrequest <- true
-	<-client.response
+	waitForState(t, srvStateChangedCh, http.StateNew, "Client failed to connect")
 
 	server.Close()
-	if err := <-srvClosedCh; err != nil {
-		t.Error("Unexpected error during shutdown", err)
-	}
+	waitForPhase(t, server, PhaseShuttingDown)
 
 	// When
 	client.sendrequest <- true
 	rr := <-client.response
 
 	// Then
-	if rr.body != nil || rr.err != nil {
+	if rr.err != nil || len(rr.body) == 0 || rr.body[0] != "HTTP/1.1 503 Service Unavailable" {
 		t.Errorf("Request should be rejected, body=%v, err=%v", rr.body, rr.err)
 	}
+	if !hasLine(rr.body, "Connection: close") {
+		t.Errorf("Rejection should close the connection, body=%v", rr.body)
+	}
+	if err := <-srvClosedCh; err != nil {
+		t.Error("Unexpected error during shutdown", err)
+	}
+	if n := server.Stats().Rejected; n != 1 {
+		t.Errorf("Expected 1 rejected request, got %d", n)
+	}
+	close(client.sendrequest)
+	<-client.closed
 }
 
 func waitForState(t *testing.T, waiter chan http.ConnState, state http.ConnState, errmsg string) {
@@ -173,9 +182,9 @@ func TestStateTransitionActiveIdleActive(t *testing.T) {
 	wg := helpers.NewWaitGroup()
 	statechanged := make(chan http.ConnState)
 	server.wg = wg
-	listener, exitchan := startServer(t, server, statechanged)
+	addr, exitchan := startServer(t, server, statechanged)
 
-	client := newClient(listener.Addr(), false)
+	client := newClient(addr, false)
 	client.Run()
 
 	// wait for client to connect, but don't let it send the request
@@ -207,7 +216,7 @@ func TestStateTransitionActiveIdleActive(t *testing.T) {
 // network connection and make sure the waitgroup count is correct at the end.
 func TestStateTransitionActiveIdleClosed(t *testing.T) {
 	var (
-		listener net.Listener
+		addr     net.Addr
 		exitchan chan error
 	)
 
@@ -226,12 +235,12 @@ func TestStateTransitionActiveIdleClosed(t *testing.T) {
 		statechanged := make(chan http.ConnState)
 		server.wg = wg
 		if withTLS {
-			listener, exitchan = startTLSServer(t, server, certFile.Name(), keyFile.Name(), statechanged)
+			addr, exitchan = startTLSServer(t, server, certFile.Name(), keyFile.Name(), statechanged)
 		} else {
-			listener, exitchan = startServer(t, server, statechanged)
+			addr, exitchan = startServer(t, server, statechanged)
 		}
 
-		client := newClient(listener.Addr(), withTLS)
+		client := newClient(addr, withTLS)
 		client.Run()
 
 		// wait for client to connect, but don't let it send the request
@@ -287,3 +296,157 @@ func TestRoutinesCount(t *testing.T) {
 		t.Errorf("Expected the routines count to equal 0; actually %d", count)
 	}
 }
+
+// Tests that a keep-alive connection is told to close once it has served
+// MaxRequestsPerConn requests.
+func TestMaxRequestsPerConn(t *testing.T) {
+	server := NewServer()
+	server.MaxRequestsPerConn = 2
+	statechanged := make(chan http.ConnState, 100)
+	addr, exitchan := startServer(t, server, statechanged)
+
+	client := newClient(addr, false)
+	client.Run()
+	if err := <-client.connected; err != nil {
+		t.Fatal("Client failed to connect to server", err)
+	}
+
+	for i := 1; i <= 2; i++ {
+		client.sendrequest <- true
+		rr := <-client.response
+		if rr.err != nil {
+			t.Fatalf("request %d: unexpected error from client %s", i, rr.err)
+		}
+		closing := false
+		for _, line := range rr.body {
+			if line == "Connection: close" {
+				closing = true
+			}
+		}
+		if closing != (i == 2) {
+			t.Errorf("request %d: expected Connection: close to be %t, headers %v", i, i == 2, rr.body)
+		}
+	}
+	waitForState(t, statechanged, http.StateClosed, "Server failed to close the connection")
+
+	close(client.sendrequest)
+	<-client.closed
+	server.Close()
+	if err := <-exitchan; err != nil {
+		t.Error("Unexpected error during shutdown", err)
+	}
+}
+
+// Tests that a server can be served again after it has shut down, without
+// wrapping its handler twice.
+func TestRestart(t *testing.T) {
+	server := NewServer()
+	addr, exitchan := startServer(t, server, nil)
+
+	for run := 1; run <= 2; run++ {
+		client := newClient(addr, false)
+		client.Run()
+		if err := <-client.connected; err != nil {
+			t.Fatalf("run %d: client failed to connect to server %s", run, err)
+		}
+		client.sendrequest <- true
+		if rr := <-client.response; rr.err != nil || len(rr.body) == 0 {
+			t.Fatalf("run %d: unexpected response %v", run, rr)
+		}
+		close(client.sendrequest)
+		<-client.closed
+
+		if !server.Close() {
+			t.Fatalf("run %d: first call to Close returned false", run)
+		}
+		if err := <-exitchan; err != nil {
+			t.Errorf("run %d: unexpected error during shutdown %s", run, err)
+		}
+		if server.Close() {
+			t.Errorf("run %d: Close on a stopped server returned true", run)
+		}
+
+		if run == 1 {
+			exitchan = make(chan error)
+			go func() {
+				exitchan <- server.ListenAndServe()
+			}()
+			<-server.Ready()
+			addr = server.ListenerAddr()
+		}
+	}
+
+	gh := server.Handler.(*gracefulHandler)
+	if _, ok := gh.generation().handler.(*gracefulHandler); ok {
+		t.Error("handler was wrapped twice")
+	}
+	if accepted := server.Stats().Accepted; accepted != 2 {
+		t.Errorf("expected 2 connections over both runs, got %d", accepted)
+	}
+}
+
+// Tests that closing a server before it starts serving neither blocks nor
+// gets lost.
+func TestCloseBeforeServe(t *testing.T) {
+	server := NewServer()
+	if !server.Close() {
+		t.Fatal("first call to Close returned false")
+	}
+	_, exitchan := startServer(t, server, nil)
+	if err := <-exitchan; err != nil {
+		t.Error("Unexpected error during shutdown", err)
+	}
+}
+
+// Tests that a server served on two listeners at once serves on both and
+// shuts down once, when both Serve calls have returned.
+func TestServeTwoListeners(t *testing.T) {
+	server := NewServer()
+	server.Handler = nullHandler
+	exitchan := make(chan error, 2)
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs = append(addrs, l.Addr().String())
+		go func() {
+			exitchan <- server.Serve(l)
+		}()
+	}
+	<-server.Ready()
+
+	for _, addr := range addrs {
+		var resp *http.Response
+		var err error
+		for i := 0; i < 100; i++ {
+			if resp, err = http.Get("http://" + addr); err == nil {
+				break
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatalf("request to %s failed: %s", addr, err)
+		}
+		resp.Body.Close()
+	}
+
+	report := server.BlockingClose()
+	for i := 0; i < 2; i++ {
+		if err := <-exitchan; err != nil {
+			t.Error("Unexpected error during shutdown", err)
+		}
+	}
+	if !report.Initiated || report.End.IsZero() {
+		t.Errorf("expected a finished shutdown report, got %+v", report)
+	}
+	if server.Phase() != PhaseStopped {
+		t.Errorf("expected the server to be stopped, got %s", server.Phase())
+	}
+	select {
+	case <-server.Ready():
+		t.Error("Ready was not reset after the shutdown")
+	default:
+	}
+}
@@ -6,42 +6,159 @@ import (
 	"sync"
 )
 
+// The package-level functions keep track of the servers they start in a
+// registry, so that several of them can run at once and be closed
+// individually or all together. A server is known by the address it was
+// started on and, once it listens, by the address it is bound to, so that
+// servers started on ":0" can be told apart.
 var (
-	defaultServer     *GracefulServer
-	defaultServerLock = &sync.Mutex{}
+	registryLock  sync.Mutex
+	registry      = make(map[*GracefulServer][]string) // running servers and their addresses.
+	pendingCloses = make(map[string]bool)              // addresses closed with no server running.
+	closeNext     bool                                 // Close() was called with no server running.
 )
 
-func init() {
-	defaultServerLock.Lock()
-}
-
 // ListenAndServe provides a graceful version of the function provided by the
-// net/http package. Call Close() to stop the server.
+// net/http package. Call Close(addr) or CloseAll() to stop the server.
 func ListenAndServe(addr string, handler http.Handler) error {
-	defaultServer = NewWithServer(&http.Server{Addr: addr, Handler: handler})
-	defaultServerLock.Unlock()
-	return defaultServer.ListenAndServe()
+	s := NewWithServer(&http.Server{Addr: addr, Handler: handler})
+	return serveRegistered(addr, s, s.ListenAndServe)
 }
 
 // ListenAndServeTLS provides a graceful version of the function provided by the
-// net/http package. Call Close() to stop the server.
+// net/http package. Call Close(addr) or CloseAll() to stop the server.
 func ListenAndServeTLS(addr string, certFile string, keyFile string, handler http.Handler) error {
-	defaultServer = NewWithServer(&http.Server{Addr: addr, Handler: handler})
-	defaultServerLock.Unlock()
-	return defaultServer.ListenAndServeTLS(certFile, keyFile)
+	s := NewWithServer(&http.Server{Addr: addr, Handler: handler})
+	return serveRegistered(addr, s, func() error {
+		return s.ListenAndServeTLS(certFile, keyFile)
+	})
 }
 
 // Serve provides a graceful version of the function provided by the net/http
-// package. Call Close() to stop the server.
+// package. The server is registered under l.Addr().String(); call Close with
+// that address or CloseAll() to stop it.
 func Serve(l net.Listener, handler http.Handler) error {
-	defaultServer = NewWithServer(&http.Server{Handler: handler})
-	defaultServerLock.Unlock()
-	return defaultServer.Serve(l)
+	s := NewWithServer(&http.Server{Handler: handler})
+	return serveRegistered(l.Addr().String(), s, func() error {
+		return s.Serve(l)
+	})
+}
+
+// serveRegistered registers s under addr, and under the address it binds to,
+// for the duration of serve. It returns nil without serving if addr, or every
+// server, was closed before it was called.
+func serveRegistered(addr string, s *GracefulServer, serve func() error) error {
+	registryLock.Lock()
+	if closeNext {
+		closeNext = false
+		registryLock.Unlock()
+		return nil
+	}
+	if pendingCloses[addr] {
+		delete(pendingCloses, addr)
+		registryLock.Unlock()
+		return nil
+	}
+	registry[s] = []string{addr}
+	registryLock.Unlock()
+
+	served := make(chan struct{})
+	go func() {
+		select {
+		case <-s.Ready():
+		case <-served:
+			return
+		}
+		bound := s.ListenerAddr().String()
+		registryLock.Lock()
+		if addrs, ok := registry[s]; ok && bound != addr {
+			registry[s] = append(addrs, bound)
+		}
+		registryLock.Unlock()
+	}()
+
+	defer func() {
+		close(served)
+		registryLock.Lock()
+		delete(registry, s)
+		registryLock.Unlock()
+	}()
+	return serve()
 }
 
-// Shuts down the default server used by ListenAndServe, ListenAndServeTLS and
-// Serve. It returns true if it's the first time Close is called.
-func Close() bool {
-	defaultServerLock.Lock()
-	return defaultServer.Close()
+// Close shuts down the servers started by ListenAndServe, ListenAndServeTLS
+// and Serve on the given addresses, or every such server if no address is
+// given. An address matches both the one a server was started on and the
+// one it is bound to. Close returns true if it's the first time Close is
+// called on any of them.
+//
+// Closing an address that has no server yet stops the next server started
+// there before it serves: that ListenAndServe call returns nil at once.
+// Likewise, calling Close with no address while no server is running stops
+// the next server started on any address. These pending closes last until
+// they stop a server or CloseAll is called.
+func Close(addrs ...string) bool {
+	if len(addrs) == 0 {
+		registryLock.Lock()
+		running := len(registry) > 0
+		if !running {
+			closeNext = true
+		}
+		registryLock.Unlock()
+		if !running {
+			return true
+		}
+		return CloseAll()
+	}
+
+	var servers []*GracefulServer
+	first := false
+	registryLock.Lock()
+	for _, addr := range addrs {
+		found := false
+		for s, serverAddrs := range registry {
+			for _, a := range serverAddrs {
+				if a == addr {
+					servers = append(servers, s)
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			pendingCloses[addr] = true
+			first = true
+		}
+	}
+	registryLock.Unlock()
+
+	for _, s := range servers {
+		if s.Close() {
+			first = true
+		}
+	}
+	return first
+}
+
+// CloseAll shuts down every running server started by ListenAndServe,
+// ListenAndServeTLS and Serve, and drops the pending closes left by Close
+// for servers that have not started. It returns true if it's the first time
+// any of the running servers is closed.
+func CloseAll() bool {
+	var servers []*GracefulServer
+	registryLock.Lock()
+	for s := range registry {
+		servers = append(servers, s)
+	}
+	pendingCloses = make(map[string]bool)
+	closeNext = false
+	registryLock.Unlock()
+
+	first := false
+	for _, s := range servers {
+		if s.Close() {
+			first = true
+		}
+	}
+	return first
 }
@@ -0,0 +1,226 @@
+package manners
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func serveDefault(t *testing.T) (string, chan error) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal("Failed to listen", err)
+	}
+	exitchan := make(chan error, 1)
+	go func() {
+		exitchan <- Serve(l, nullHandler)
+	}()
+
+	// wait for the server to be registered
+	addr := l.Addr().String()
+	for i := 0; len(registeredOn(addr)) == 0; i++ {
+		if i == 100 {
+			t.Fatal("Server failed to start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return addr, exitchan
+}
+
+// registeredOn returns the addresses of the running servers started or
+// bound on addr.
+func registeredOn(addr string) [][]string {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	var found [][]string
+	for _, addrs := range registry {
+		for _, a := range addrs {
+			if a == addr {
+				found = append(found, append([]string(nil), addrs...))
+				break
+			}
+		}
+	}
+	return found
+}
+
+// Tests that servers started through the package-level functions can be
+// closed individually and all together.
+func TestCloseByAddr(t *testing.T) {
+	addr1, exit1 := serveDefault(t)
+	addr2, exit2 := serveDefault(t)
+
+	if !Close(addr1) {
+		t.Fatal("first call to Close returned false")
+	}
+	if err := <-exit1; err != nil {
+		t.Error("Unexpected error during shutdown", err)
+	}
+	select {
+	case <-exit2:
+		t.Fatalf("closing %s stopped %s", addr1, addr2)
+	default:
+	}
+
+	CloseAll()
+	if err := <-exit2; err != nil {
+		t.Error("Unexpected error during shutdown", err)
+	}
+}
+
+// Tests that closing an address before a server is started on it makes that
+// server return straight away instead of deadlocking.
+func TestCloseBeforeStart(t *testing.T) {
+	addr := "localhost:0"
+	if !Close(addr) {
+		t.Fatal("first call to Close returned false")
+	}
+	if err := ListenAndServe(addr, nullHandler); err != nil {
+		t.Error("Unexpected error from a closed server", err)
+	}
+
+	// The close only applies to the next server started on the address.
+	registryLock.Lock()
+	pending := pendingCloses[addr]
+	registryLock.Unlock()
+	if pending {
+		t.Error("Close before start should only stop one server")
+	}
+}
+
+// Tests that Close does not block when a server fails to start.
+func TestCloseFailedServer(t *testing.T) {
+	if err := ListenAndServe("localhost:-1", nullHandler); err == nil {
+		t.Fatal("expected an error listening on an invalid port")
+	}
+	// Must not wait for the failed server to start.
+	CloseAll()
+}
+
+// Tests that Close with no address called before any server starts stops
+// the next server, as when a signal handler runs before ListenAndServe.
+func TestCloseAllBeforeStart(t *testing.T) {
+	// Stop servers left running by other tests.
+	CloseAll()
+	for i := 0; ; i++ {
+		registryLock.Lock()
+		n := len(registry)
+		registryLock.Unlock()
+		if n == 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatal("servers from other tests did not stop")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !Close() {
+		t.Fatal("first call to Close returned false")
+	}
+	exitchan := make(chan error, 1)
+	go func() {
+		exitchan <- ListenAndServe("localhost:0", nullHandler)
+	}()
+	select {
+	case err := <-exitchan:
+		if err != nil {
+			t.Error("Unexpected error from a closed server", err)
+		}
+	case <-time.After(time.Second):
+		CloseAll()
+		t.Fatal("server started after Close kept running")
+	}
+}
+
+// Tests that several servers can be started on ":0" and closed one by one by
+// the address they are bound to.
+func TestListenAndServeAnyPort(t *testing.T) {
+	exit1, exit2 := make(chan error, 1), make(chan error, 1)
+	go func() { exit1 <- ListenAndServe("localhost:0", nullHandler) }()
+	go func() { exit2 <- ListenAndServe("localhost:0", nullHandler) }()
+
+	var servers [][]string
+	for i := 0; ; i++ {
+		servers = registeredOn("localhost:0")
+		if len(servers) == 2 && len(servers[0]) == 2 && len(servers[1]) == 2 {
+			break
+		}
+		select {
+		case err := <-exit1:
+			t.Fatal("first server failed to start", err)
+		case err := <-exit2:
+			t.Fatal("second server failed to start", err)
+		default:
+		}
+		if i == 100 {
+			t.Fatalf("servers failed to start, got %v", servers)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !Close(servers[0][1]) {
+		t.Fatal("first call to Close returned false")
+	}
+	var stillRunning chan error
+	select {
+	case err := <-exit1:
+		stillRunning = exit2
+		if err != nil {
+			t.Error("Unexpected error during shutdown", err)
+		}
+	case err := <-exit2:
+		stillRunning = exit1
+		if err != nil {
+			t.Error("Unexpected error during shutdown", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("closing a bound address did not stop its server")
+	}
+	if len(registeredOn(servers[1][1])) != 1 {
+		t.Errorf("closing %s stopped %s", servers[0][1], servers[1][1])
+	}
+
+	Close(servers[1][1])
+	if err := <-stillRunning; err != nil {
+		t.Error("Unexpected error during shutdown", err)
+	}
+}
+
+// Tests that CloseAll drops the closes pending for servers not started yet.
+func TestCloseAllDropsPendingCloses(t *testing.T) {
+	CloseAll()
+	for i := 0; ; i++ {
+		registryLock.Lock()
+		n := len(registry)
+		registryLock.Unlock()
+		if n == 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatal("servers from other tests did not stop")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	Close()
+	Close("localhost:0")
+	CloseAll()
+	registryLock.Lock()
+	pending := len(pendingCloses)
+	registryLock.Unlock()
+	if pending != 0 {
+		t.Errorf("expected CloseAll to drop the pending closes, %d left", pending)
+	}
+
+	addr, exitchan := serveDefault(t)
+	select {
+	case err := <-exitchan:
+		t.Fatal("a server started after CloseAll was stopped by an earlier Close", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	Close(addr)
+	if err := <-exitchan; err != nil {
+		t.Error("Unexpected error during shutdown", err)
+	}
+}
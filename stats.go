@@ -0,0 +1,202 @@
+package manners
+
+import (
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a point-in-time snapshot of a GracefulServer's connection and
+// request accounting.
+type Stats struct {
+	// Connections counts the open connections by their current state.
+	Connections map[http.ConnState]int
+
+	// InFlight is the number of requests currently being handled, and
+	// InFlightByPriority breaks it down by DrainPriority.
+	InFlight           int64
+	InFlightByPriority map[DrainPriority]int64
+
+	// Routines is the value of RoutinesCount.
+	Routines int
+
+	// Accepted, Closed and Hijacked are running totals of connections that
+	// were accepted, closed, and hijacked from the server.
+	Accepted uint64
+	Closed   uint64
+	Hijacked uint64
+
+	// Rejected counts requests that arrived on a kept-alive connection after
+	// shutdown started and were not passed to the handler.
+	Rejected uint64
+
+	// ForcedClosed counts connections closed by the server because the
+	// ShutdownPolicy gave up on draining them.
+	ForcedClosed uint64
+
+	// Panics counts the panics recovered from routines started with Go and,
+	// if RecoverPanics is set, from the handler.
+	Panics uint64
+
+	// Listeners counts the connections accepted on each listener the server
+	// has served, keyed by the listener's address.
+	Listeners map[string]uint64
+
+	// Phase is the server's lifecycle phase.
+	Phase Phase
+
+	// ShutdownDuration is how long the shutdown has been running, or how long
+	// it took once it has finished. It is zero before Close is called.
+	ShutdownDuration time.Duration
+}
+
+// counters holds the running totals reported in Stats. All fields are
+// accessed atomically.
+type counters struct {
+	inFlight     int64
+	accepted     uint64
+	closed       uint64
+	hijacked     uint64
+	rejected     uint64
+	forcedClosed uint64
+	panics       uint64
+}
+
+// snapshot returns a copy of c, read atomically.
+func (c *counters) snapshot() counters {
+	return counters{
+		inFlight:     atomic.LoadInt64(&c.inFlight),
+		accepted:     atomic.LoadUint64(&c.accepted),
+		closed:       atomic.LoadUint64(&c.closed),
+		hijacked:     atomic.LoadUint64(&c.hijacked),
+		rejected:     atomic.LoadUint64(&c.rejected),
+		forcedClosed: atomic.LoadUint64(&c.forcedClosed),
+		panics:       atomic.LoadUint64(&c.panics),
+	}
+}
+
+// Stats returns a snapshot of the server's connection and request counts.
+func (s *GracefulServer) Stats() Stats {
+	c := s.counters.snapshot()
+	st := Stats{
+		Connections: map[http.ConnState]int{
+			http.StateNew:    0,
+			http.StateActive: 0,
+			http.StateIdle:   0,
+		},
+		Listeners:    make(map[string]uint64),
+		Phase:        s.Phase(),
+		InFlight:     c.inFlight,
+		Accepted:     c.accepted,
+		Closed:       c.closed,
+		Hijacked:     c.hijacked,
+		Rejected:     c.rejected,
+		ForcedClosed: c.forcedClosed,
+		Panics:       c.panics,
+	}
+
+	s.lcsmu.RLock()
+	for _, tc := range s.connections {
+		st.Connections[tc.state]++
+	}
+	for addr, n := range s.listeners {
+		st.Listeners[addr] = atomic.LoadUint64(n)
+	}
+	st.InFlightByPriority = make(map[DrainPriority]int64, len(s.classes))
+	for p, c := range s.classes {
+		st.InFlightByPriority[p] = atomic.LoadInt64(&c.inFlight)
+	}
+	st.Routines = s.routinesCount
+	switch {
+	case s.report.Start.IsZero():
+	case s.report.End.IsZero():
+		st.ShutdownDuration = time.Since(s.report.Start)
+	default:
+		st.ShutdownDuration = s.report.End.Sub(s.report.Start)
+	}
+	s.lcsmu.RUnlock()
+
+	return st
+}
+
+// A Phase describes where a GracefulServer is in its lifecycle.
+type Phase int32
+
+const (
+	// PhaseIdle is the phase of a server that has not started serving.
+	PhaseIdle Phase = iota
+	// PhaseServing is the phase of a server accepting connections.
+	PhaseServing
+	// PhaseShuttingDown is the phase of a server that has stopped accepting
+	// connections and is waiting for the last routine to finish.
+	PhaseShuttingDown
+	// PhaseStopped is the phase of a server that has shut down.
+	PhaseStopped
+	// PhasePaused is the phase of a server that has been paused and is not
+	// accepting connections.
+	PhasePaused
+	// PhasePreStop is the phase of a server that keeps serving for its
+	// ShutdownPolicy's PreStopDelay after Close is called.
+	PhasePreStop
+	// PhaseForcing is the phase of a server that has given up on draining
+	// and closed its remaining connections.
+	PhaseForcing
+)
+
+var phaseNames = []string{"idle", "serving", "shutting down", "stopped", "paused", "pre-stop", "forcing"}
+
+func (p Phase) String() string {
+	if p < 0 || int(p) >= len(phaseNames) {
+		return "unknown"
+	}
+	return phaseNames[p]
+}
+
+// Phase returns the server's current lifecycle phase.
+func (s *GracefulServer) Phase() Phase {
+	return Phase(atomic.LoadInt32(&s.phase))
+}
+
+func (s *GracefulServer) setPhase(p Phase) {
+	atomic.StoreInt32(&s.phase, int32(p))
+	s.emit(Event{Kind: EventPhase, Phase: p})
+}
+
+// swapPhase moves the server to phase to if it is in phase from, and
+// reports whether it did.
+func (s *GracefulServer) swapPhase(from, to Phase) bool {
+	if atomic.CompareAndSwapInt32(&s.phase, int32(from), int32(to)) {
+		s.emit(Event{Kind: EventPhase, Phase: to})
+		return true
+	}
+	return false
+}
+
+// countAccepts wraps l so that the connections it accepts are counted in
+// Stats.Listeners.
+func (s *GracefulServer) countAccepts(l net.Listener) net.Listener {
+	addr := l.Addr().String()
+	s.lcsmu.Lock()
+	n, ok := s.listeners[addr]
+	if !ok {
+		n = new(uint64)
+		s.listeners[addr] = n
+	}
+	s.lcsmu.Unlock()
+	return &countingListener{Listener: l, accepted: n}
+}
+
+// countingListener counts the connections accepted by the wrapped listener.
+type countingListener struct {
+	net.Listener
+	accepted *uint64 // accessed atomically.
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		atomic.AddUint64(l.accepted, 1)
+	}
+	return conn, err
+}
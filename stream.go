@@ -0,0 +1,153 @@
+package manners
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// A Stream writes a long-lived response, such as Server-Sent Events or a
+// chunked stream, that ends by itself when the server starts shutting down
+// instead of holding up the drain forever.
+//
+// Streaming responses cannot be buffered, so RequestTimeout should be
+// disabled for their paths with SetRequestTimeout.
+type Stream struct {
+	// Final is written to the client when the stream ends because the server
+	// is shutting down, for instance to tell it to reconnect.
+	Final []byte
+
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	client  context.Context // the request's context.
+	closing <-chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// NewStream starts a chunked response to r, flushing its headers at once.
+// It fails if w does not support flushing.
+func (s *GracefulServer) NewStream(w http.ResponseWriter, r *http.Request) (*Stream, error) {
+	st := &Stream{w: w, rc: http.NewResponseController(w), client: r.Context()}
+	if err := st.rc.Flush(); err != nil {
+		return nil, err
+	}
+
+	s.lcsmu.RLock()
+	st.closing = s.closing
+	s.lcsmu.RUnlock()
+
+	st.ctx, st.cancel = context.WithCancel(r.Context())
+	go func() {
+		select {
+		case <-st.closing:
+			st.cancel()
+		case <-st.ctx.Done():
+		}
+	}()
+	return st, nil
+}
+
+// NewEventStream starts a Server-Sent Events response to r. Its Final event
+// asks the client to reconnect after a second.
+func (s *GracefulServer) NewEventStream(w http.ResponseWriter, r *http.Request) (*Stream, error) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	st, err := s.NewStream(w, r)
+	if err != nil {
+		return nil, err
+	}
+	st.Final = StreamEvent{Event: "reconnect", Data: "server shutting down", Retry: time.Second}.Bytes()
+	return st, nil
+}
+
+// Context returns a context that is cancelled when the client goes away or
+// the server starts shutting down.
+func (st *Stream) Context() context.Context {
+	return st.ctx
+}
+
+// Write writes p to the client and flushes it.
+func (st *Stream) Write(p []byte) (int, error) {
+	n, err := st.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, st.rc.Flush()
+}
+
+// Send writes ev to the client and flushes it.
+func (st *Stream) Send(ev StreamEvent) error {
+	_, err := st.Write(ev.Bytes())
+	return err
+}
+
+// Forward writes each chunk received from chunks to the client until chunks
+// is closed, the client goes away or the server starts shutting down. In the
+// last case it writes Final and returns nil; if the client went away it
+// returns the context's error.
+func (st *Stream) Forward(chunks <-chan []byte) error {
+	defer st.cancel()
+	for {
+		select {
+		case chunk, ok := <-chunks:
+			if !ok {
+				return nil
+			}
+			if _, err := st.Write(chunk); err != nil {
+				return err
+			}
+		case <-st.ctx.Done():
+			return st.End()
+		}
+	}
+}
+
+// End writes Final if the server is shutting down and the client is still
+// there, and releases the stream. It returns the context's error if the
+// client went away.
+func (st *Stream) End() error {
+	st.cancel()
+	if err := st.client.Err(); err != nil {
+		return err
+	}
+	select {
+	case <-st.closing:
+		if st.Final != nil {
+			_, err := st.Write(st.Final)
+			return err
+		}
+	default:
+	}
+	return nil
+}
+
+// A StreamEvent is a Server-Sent Event.
+type StreamEvent struct {
+	ID    string
+	Event string
+	Data  string
+	Retry time.Duration // reconnection delay hint, if positive.
+}
+
+// Bytes returns the event in the text/event-stream format.
+func (ev StreamEvent) Bytes() []byte {
+	var buf bytes.Buffer
+	if ev.ID != "" {
+		fmt.Fprintf(&buf, "id: %s\n", ev.ID)
+	}
+	if ev.Event != "" {
+		fmt.Fprintf(&buf, "event: %s\n", ev.Event)
+	}
+	if ev.Retry > 0 {
+		fmt.Fprintf(&buf, "retry: %d\n", ev.Retry.Milliseconds())
+	}
+	for _, line := range strings.Split(ev.Data, "\n") {
+		fmt.Fprintf(&buf, "data: %s\n", line)
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
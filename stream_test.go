@@ -0,0 +1,73 @@
+package manners
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Tests that an event stream ends with its final event when the server
+// shuts down, letting the drain complete.
+func TestEventStreamShutdown(t *testing.T) {
+	server := NewServer()
+	addr, exitchan := startServer(t, server, nil)
+	server.SwapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st, err := server.NewEventStream(w, r)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		st.Send(StreamEvent{ID: "1", Data: "hello"})
+		if err := st.Forward(make(chan []byte)); err != nil {
+			t.Error("Unexpected error ending the stream", err)
+		}
+	}))
+
+	resp, err := http.Get("http://" + addr.String() + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Expected an event stream, got %q", ct)
+	}
+	body := bufio.NewReader(resp.Body)
+	for {
+		line, err := body.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line == "\n" {
+			break
+		}
+	}
+
+	server.Close()
+	rest, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(rest), "event: reconnect\nretry: 1000\n") {
+		t.Errorf("Expected the final event, got %q", rest)
+	}
+
+	select {
+	case err := <-exitchan:
+		if err != nil {
+			t.Error("Unexpected error during shutdown", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return")
+	}
+}
+
+func TestStreamEventBytes(t *testing.T) {
+	ev := StreamEvent{ID: "7", Event: "update", Data: "a\nb", Retry: 2 * time.Second}
+	want := "id: 7\nevent: update\nretry: 2000\ndata: a\ndata: b\n\n"
+	if got := string(ev.Bytes()); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,80 @@
+package manners
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// A Routine is a function started with GracefulServer.Go.
+type Routine struct {
+	done chan struct{}
+	err  error
+}
+
+// Done returns a channel that is closed when the routine has returned.
+func (r *Routine) Done() <-chan struct{} {
+	return r.done
+}
+
+// Err returns a *PanicError if the routine panicked, and nil otherwise. It
+// must only be called once Done is closed.
+func (r *Routine) Err() error {
+	return r.err
+}
+
+// A PanicError records a panic recovered by the server.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("manners: panic: %v", e.Value)
+}
+
+// Go runs fn in its own goroutine, counted as a routine like StartRoutine
+// does, so that the server waits for it to return before shutting down. The
+// context passed to fn is cancelled when the ShutdownPolicy gives up on
+// draining and the server starts closing connections.
+//
+// A panic in fn is recovered, logged through the Server's ErrorLog, counted
+// in Stats and reported by the returned Routine's Err.
+func (s *GracefulServer) Go(fn func(ctx context.Context)) *Routine {
+	return s.goRoutine("routine", fn)
+}
+
+// goRoutine implements Go, describing the routine as what when logging a
+// panic.
+func (s *GracefulServer) goRoutine(what string, fn func(ctx context.Context)) *Routine {
+	s.lcsmu.RLock()
+	ctx := s.routinesCtx
+	s.lcsmu.RUnlock()
+
+	r := &Routine{done: make(chan struct{})}
+	s.StartRoutine()
+	go func() {
+		defer close(r.done)
+		defer s.FinishRoutine()
+		defer func() {
+			if v := recover(); v != nil {
+				perr := &PanicError{Value: v, Stack: debug.Stack()}
+				r.err = perr
+				atomic.AddUint64(&s.counters.panics, 1)
+				s.logf("%v in %s\n%s", perr, what, perr.Stack)
+			}
+		}()
+		fn(ctx)
+	}()
+	return r
+}
+
+// resetRoutinesContext gives routines started from now on a fresh context.
+// It must be called with lcsmu held.
+func (s *GracefulServer) resetRoutinesContext() {
+	if s.cancelRoutines != nil {
+		s.cancelRoutines()
+	}
+	s.routinesCtx, s.cancelRoutines = context.WithCancel(context.Background())
+}
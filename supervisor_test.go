@@ -0,0 +1,86 @@
+package manners
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"testing"
+	"time"
+)
+
+// Tests that the server waits for routines started with Go before shutting
+// down.
+func TestGoDrained(t *testing.T) {
+	server := NewServer()
+	_, exitchan := startServer(t, server, nil)
+
+	release := make(chan struct{})
+	r := server.Go(func(ctx context.Context) {
+		<-release
+	})
+	server.Close()
+
+	select {
+	case <-exitchan:
+		t.Fatal("Serve returned before the routine finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-exitchan; err != nil {
+		t.Error("Unexpected error during shutdown", err)
+	}
+	<-r.Done()
+	if r.Err() != nil {
+		t.Errorf("Expected no error, got %v", r.Err())
+	}
+}
+
+// Tests that the context of a routine is cancelled when the drain timeout
+// passes.
+func TestGoCancelledOnForcedClose(t *testing.T) {
+	server := NewServer()
+	server.ShutdownPolicy.DrainTimeout = 20 * time.Millisecond
+	_, exitchan := startServer(t, server, nil)
+
+	r := server.Go(func(ctx context.Context) {
+		<-ctx.Done()
+	})
+	server.Close()
+
+	select {
+	case <-r.Done():
+	case <-time.After(time.Second):
+		t.Fatal("routine was not cancelled")
+	}
+	if err := <-exitchan; err != nil {
+		t.Error("Unexpected error during shutdown", err)
+	}
+}
+
+// Tests that a panicking routine is recovered, reported and released.
+func TestGoPanic(t *testing.T) {
+	var logged bytes.Buffer
+	server := NewServer()
+	server.ErrorLog = log.New(&logged, "", 0)
+
+	r := server.Go(func(ctx context.Context) {
+		panic("boom")
+	})
+	<-r.Done()
+
+	var perr *PanicError
+	if !errors.As(r.Err(), &perr) || perr.Value != "boom" {
+		t.Fatalf("Expected a PanicError, got %v", r.Err())
+	}
+	if server.RoutinesCount() != 0 {
+		t.Errorf("Expected no routines left, got %d", server.RoutinesCount())
+	}
+	if server.Stats().Panics != 1 {
+		t.Errorf("Expected 1 panic counted, got %d", server.Stats().Panics)
+	}
+	if !bytes.Contains(logged.Bytes(), []byte("boom")) {
+		t.Errorf("Expected the panic to be logged, got %q", logged.String())
+	}
+}
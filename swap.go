@@ -0,0 +1,111 @@
+package manners
+
+import (
+	"net/http"
+	"sync"
+)
+
+// SwapHandler atomically replaces the handler the server passes requests
+// to, for example to switch to a maintenance page or to a router rebuilt
+// after a configuration reload. Requests that have already started keep
+// running on the old handler; the returned channel is closed once the last
+// of them has finished, after which the old handler's resources can be
+// released. A nil handler means http.DefaultServeMux.
+func (s *GracefulServer) SwapHandler(h http.Handler) <-chan struct{} {
+	s.lcsmu.Lock()
+	gh := s.handler
+	if gh == nil || s.Server.Handler != http.Handler(gh) {
+		// Not serving yet; Serve will wrap the new handler.
+		s.Server.Handler = h
+		s.lcsmu.Unlock()
+		drained := make(chan struct{})
+		close(drained)
+		return drained
+	}
+	s.lcsmu.Unlock()
+	return gh.swap(h)
+}
+
+// A handlerGeneration is one of the handlers a gracefulHandler has wrapped
+// over its lifetime, with the requests currently running on it.
+type handlerGeneration struct {
+	handler http.Handler
+
+	mu      sync.Mutex
+	active  int
+	retired bool
+	drained chan struct{} // closed once retired with no active requests.
+}
+
+func newHandlerGeneration(h http.Handler) *handlerGeneration {
+	if h == nil {
+		h = http.DefaultServeMux
+	}
+	return &handlerGeneration{handler: h, drained: make(chan struct{})}
+}
+
+// acquire counts a request as running on the generation. It returns false if
+// the generation has been retired and the request must use its successor.
+func (g *handlerGeneration) acquire() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.retired {
+		return false
+	}
+	g.active++
+	return true
+}
+
+func (g *handlerGeneration) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.active--
+	if g.retired && g.active == 0 {
+		close(g.drained)
+	}
+}
+
+func (g *handlerGeneration) retire() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.retired = true
+	if g.active == 0 {
+		close(g.drained)
+	}
+}
+
+// serveWrapped passes the request to the current generation of the wrapped
+// handler.
+func (gh *gracefulHandler) serveWrapped(w http.ResponseWriter, r *http.Request) {
+	for {
+		gen := gh.generation()
+		if gen.acquire() {
+			defer gen.release()
+			h := gen.handler
+			if gh.limit != nil {
+				h = gh.limit(r, h)
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+	}
+}
+
+func (gh *gracefulHandler) generation() *handlerGeneration {
+	gh.mu.RLock()
+	defer gh.mu.RUnlock()
+	return gh.wrapped
+}
+
+// swap makes h the wrapped handler and retires the previous one, returning a
+// channel that is closed once the previous handler has no requests left.
+func (gh *gracefulHandler) swap(h http.Handler) <-chan struct{} {
+	next := newHandlerGeneration(h)
+	gh.mu.Lock()
+	prev := gh.wrapped
+	gh.wrapped = next
+	gh.mu.Unlock()
+
+	prev.retire()
+	return prev.drained
+}
@@ -0,0 +1,118 @@
+package manners
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// namedHandler returns a handler that tells it served the request by name.
+// If entered is not nil, the handler signals on it once it is running; if
+// release is not nil, it then waits on it before answering.
+func namedHandler(name string, entered, release chan bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if entered != nil {
+			entered <- true
+		}
+		if release != nil {
+			<-release
+		}
+		w.Header().Set("X-Handler", name)
+	})
+}
+
+func hasLine(lines []string, line string) bool {
+	for _, l := range lines {
+		if l == line {
+			return true
+		}
+	}
+	return false
+}
+
+// Tests that swapping the handler sends new requests to the new handler and
+// reports when the requests running on the old one have finished.
+func TestSwapHandler(t *testing.T) {
+	server := NewServer()
+	addr, exitchan := startServer(t, server, nil)
+
+	entered, release := make(chan bool, 1), make(chan bool)
+	server.SwapHandler(namedHandler("old", entered, release))
+
+	client1 := newClient(addr, false)
+	client1.Run()
+	<-client1.connected
+	client1.sendrequest <- true
+	// Wait for the handler itself rather than StateActive, which net/http
+	// reports before the request is counted on the old handler.
+	<-entered
+
+	drained := server.SwapHandler(namedHandler("new", nil, nil))
+
+	client2 := newClient(addr, false)
+	client2.Run()
+	<-client2.connected
+	client2.sendrequest <- true
+	if rr := <-client2.response; !hasLine(rr.body, "X-Handler: new") {
+		t.Errorf("expected the request to be served by the new handler, got %v", rr.body)
+	}
+
+	select {
+	case <-drained:
+		t.Fatal("old handler reported drained with a request still running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	release <- true
+	if rr := <-client1.response; !hasLine(rr.body, "X-Handler: old") {
+		t.Errorf("expected the running request to finish on the old handler, got %v", rr.body)
+	}
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+		t.Fatal("old handler was not reported drained")
+	}
+
+	close(client1.sendrequest)
+	close(client2.sendrequest)
+	<-client1.closed
+	<-client2.closed
+	server.Close()
+	if err := <-exitchan; err != nil {
+		t.Error("Unexpected error during shutdown", err)
+	}
+}
+
+// Tests that swapping the handler while the server starts is safe; run
+// with -race.
+func TestSwapHandlerWhileStarting(t *testing.T) {
+	server := NewServer()
+	server.Addr = "localhost:0"
+	server.Handler = namedHandler("first", nil, nil)
+
+	swapped := make(chan struct{})
+	go func() {
+		<-server.SwapHandler(namedHandler("second", nil, nil))
+		close(swapped)
+	}()
+	exitchan := make(chan error, 1)
+	go func() {
+		exitchan <- server.ListenAndServe()
+	}()
+	<-server.Ready()
+	<-swapped
+
+	resp, err := http.Get("http://" + server.ListenerAddr().String())
+	if err != nil {
+		t.Fatal("request failed", err)
+	}
+	resp.Body.Close()
+	if name := resp.Header.Get("X-Handler"); name != "second" {
+		t.Errorf("Expected the swapped handler to serve, got %q", name)
+	}
+
+	server.Close()
+	if err := <-exitchan; err != nil {
+		t.Error("Unexpected error during shutdown", err)
+	}
+}
@@ -0,0 +1,203 @@
+package manners
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// SetRequestTimeout overrides RequestTimeout for the requests whose path
+// starts with prefix. The longest matching prefix wins. A zero or negative
+// timeout disables the timeout for those requests, drain budget included.
+func (s *GracefulServer) SetRequestTimeout(prefix string, timeout time.Duration) {
+	s.lcsmu.Lock()
+	defer s.lcsmu.Unlock()
+	if s.timeouts == nil {
+		s.timeouts = make(map[string]time.Duration)
+	}
+	s.timeouts[prefix] = timeout
+}
+
+// limitRequest applies the timeout of r, if it has one, to h, along with
+// the drain budget.
+func (s *GracefulServer) limitRequest(r *http.Request, h http.Handler) http.Handler {
+	s.lcsmu.RLock()
+	timeout, ok := longestPrefix(s.timeouts, r.URL.Path)
+	budget := s.budgetCtx
+	s.lcsmu.RUnlock()
+	if !ok {
+		timeout = s.RequestTimeout
+	}
+	if timeout <= 0 {
+		return h
+	}
+
+	th := &timeoutHandler{handler: h, timeout: timeout}
+	if s.ShutdownPolicy.DrainTimeout > 0 {
+		th.budget = budget
+		th.cutOff = s.abortRequest
+	}
+	return th
+}
+
+// timedOutGrace is how long the server waits, once the drain budget has run
+// out, for the requests it cut off to be answered before it closes their
+// connections.
+const timedOutGrace = 100 * time.Millisecond
+
+// limitsRequests reports whether request timeouts are configured.
+func (s *GracefulServer) limitsRequests() bool {
+	s.lcsmu.RLock()
+	defer s.lcsmu.RUnlock()
+	return s.RequestTimeout > 0 || len(s.timeouts) > 0
+}
+
+// resetBudget gives the requests served from now on a fresh drain budget.
+// It must be called with lcsmu held.
+func (s *GracefulServer) resetBudget() {
+	if s.cancelBudget != nil {
+		s.cancelBudget()
+	}
+	s.budgetCtx, s.cancelBudget = context.WithCancel(context.Background())
+}
+
+// startBudget arranges for the contexts of the requests still running to be
+// cancelled once the drain budget, the PreStopDelay plus the DrainTimeout
+// from the start of the shutdown, runs out. Requests with a timeout are then
+// answered with 503 Service Unavailable.
+func (s *GracefulServer) startBudget() {
+	policy := s.ShutdownPolicy
+	if policy.DrainTimeout <= 0 {
+		return
+	}
+	s.lcsmu.RLock()
+	cancel := s.cancelBudget
+	deadline := s.report.Start.Add(policy.PreStopDelay + policy.DrainTimeout)
+	s.lcsmu.RUnlock()
+	time.AfterFunc(time.Until(deadline), cancel)
+}
+
+var (
+	errRequestTimeout = errors.New("manners: request timed out")
+	errDrainBudget    = errors.New("manners: drain budget ran out")
+)
+
+// A timeoutHandler answers with 503 Service Unavailable the requests its
+// handler has not answered within the timeout, or by the time the drain
+// budget runs out. Unlike http.TimeoutHandler, it only returns once the
+// handler has, so that the request stays in flight, and the server keeps
+// waiting for it, for as long as its handler runs.
+type timeoutHandler struct {
+	handler http.Handler
+	timeout time.Duration
+	budget  context.Context     // nil if the drain budget does not apply.
+	cutOff  func(*http.Request) // called when the drain budget cuts a request off.
+}
+
+func (th *timeoutHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancelCause(r.Context())
+	defer cancel(nil)
+	timer := time.AfterFunc(th.timeout, func() { cancel(errRequestTimeout) })
+	defer timer.Stop()
+	if th.budget != nil {
+		stop := context.AfterFunc(th.budget, func() { cancel(errDrainBudget) })
+		defer stop()
+	}
+
+	tw := &timeoutWriter{header: make(http.Header)}
+	done := make(chan struct{})
+	panicked := make(chan interface{}, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				panicked <- p
+			}
+		}()
+		th.handler.ServeHTTP(tw, r.WithContext(ctx))
+		close(done)
+	}()
+
+	select {
+	case p := <-panicked:
+		panic(p)
+	case <-done:
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+		dst := w.Header()
+		for k, v := range tw.header {
+			dst[k] = v
+		}
+		if !tw.wroteHeader {
+			tw.code = http.StatusOK
+		}
+		w.WriteHeader(tw.code)
+		w.Write(tw.body.Bytes())
+		return
+	case <-ctx.Done():
+	}
+
+	tw.mu.Lock()
+	tw.timedOut = true
+	tw.mu.Unlock()
+	cause := context.Cause(ctx)
+	if cause == errRequestTimeout || cause == errDrainBudget {
+		// The connection stays busy until the handler returns, so do not
+		// let the client send anything else on it.
+		w.Header().Set("Connection", "close")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(w, http.StatusText(http.StatusServiceUnavailable))
+		http.NewResponseController(w).Flush()
+	}
+	if cause == errDrainBudget && th.cutOff != nil {
+		th.cutOff(r)
+	}
+
+	select {
+	case p := <-panicked:
+		panic(p)
+	case <-done:
+	}
+}
+
+// A timeoutWriter buffers the response of a timeoutHandler's handler until
+// it returns, and drops it if it times out first.
+type timeoutWriter struct {
+	mu          sync.Mutex
+	header      http.Header
+	body        bytes.Buffer
+	code        int
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) Header() http.Header { return tw.header }
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !tw.wroteHeader {
+		tw.writeHeaderLocked(http.StatusOK)
+	}
+	return tw.body.Write(p)
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.wroteHeader {
+		return
+	}
+	tw.writeHeaderLocked(code)
+}
+
+func (tw *timeoutWriter) writeHeaderLocked(code int) {
+	tw.wroteHeader = true
+	tw.code = code
+}
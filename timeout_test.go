@@ -0,0 +1,177 @@
+package manners
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Tests that a slow request times out with a 503 and a cancelled context,
+// and that a prefix override lets another one through.
+func TestRequestTimeout(t *testing.T) {
+	server := NewServer()
+	server.RequestTimeout = 20 * time.Millisecond
+	server.SetRequestTimeout("/slow", time.Second)
+	addr, exitchan := startServer(t, server, nil)
+
+	cancelled := make(chan bool, 1)
+	server.SwapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/slow") {
+			time.Sleep(50 * time.Millisecond)
+			w.Write([]byte("done"))
+			return
+		}
+		<-r.Context().Done()
+		cancelled <- true
+	}))
+
+	get := func(path string) int {
+		resp, err := http.Get("http://" + addr.String() + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := get("/fast"); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503, got %d", code)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("request context was not cancelled")
+	}
+	if code := get("/slow/path"); code != http.StatusOK {
+		t.Errorf("Expected 200 under the override, got %d", code)
+	}
+
+	server.Close()
+	if err := <-exitchan; err != nil {
+		t.Error("Unexpected error during shutdown", err)
+	}
+}
+
+// Tests that requests already running when Close is called are cut off
+// with a 503 once the drain budget runs out, while those on a path with
+// timeouts disabled are left alone and can still stream.
+func TestRequestTimeoutDuringDrain(t *testing.T) {
+	server := NewServer()
+	server.RequestTimeout = time.Hour
+	server.SetRequestTimeout("/stream", 0)
+	server.ShutdownPolicy.PreStopDelay = 50 * time.Millisecond
+	server.ShutdownPolicy.DrainTimeout = 100 * time.Millisecond
+	addr, exitchan := startServer(t, server, nil)
+
+	started := make(chan string, 2)
+	release := make(chan struct{})
+	flushErr := make(chan error, 1)
+	server.SwapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stream" {
+			flushErr <- http.NewResponseController(w).Flush()
+			started <- r.URL.Path
+			<-release
+			return
+		}
+		started <- r.URL.Path
+		<-r.Context().Done()
+	}))
+
+	codes := make(chan int, 2)
+	get := func(path string) {
+		resp, err := http.Get("http://" + addr.String() + path)
+		if err != nil {
+			codes <- 0
+			return
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		codes <- resp.StatusCode
+	}
+
+	go get("/slow")
+	<-started
+	begin := time.Now()
+	server.Close()
+
+	// A streaming request starting during the pre-stop delay must not be
+	// buffered.
+	go get("/stream")
+	<-started
+	if err := <-flushErr; err != nil {
+		t.Errorf("Expected the exempt request to support Flush, got %v", err)
+	}
+
+	if code := <-codes; code != http.StatusServiceUnavailable {
+		t.Errorf("Expected the running request to get 503, got %d", code)
+	}
+	if elapsed := time.Since(begin); elapsed > time.Second {
+		t.Errorf("Expected the request to be cut off by the drain budget, took %s", elapsed)
+	}
+	close(release)
+	if code := <-codes; code != http.StatusOK {
+		t.Errorf("Expected the exempt request to finish, got %d", code)
+	}
+	<-exitchan
+}
+
+// Tests that a request cut off by the drain budget stays in flight until its
+// handler returns, even if the handler ignores its context, and makes the
+// shutdown unclean.
+func TestRequestTimeoutIgnoredContext(t *testing.T) {
+	server := NewServer()
+	server.RequestTimeout = time.Hour
+	server.ShutdownPolicy.DrainTimeout = 50 * time.Millisecond
+	addr, exitchan := startServer(t, server, nil)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	returned := make(chan struct{})
+	server.SwapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(returned)
+		close(started)
+		<-release
+	}))
+
+	codes := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr.String() + "/ignore")
+		if err != nil {
+			codes <- 0
+			return
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		codes <- resp.StatusCode
+	}()
+	<-started
+	server.Close()
+
+	if code := <-codes; code != http.StatusServiceUnavailable {
+		t.Errorf("Expected the running request to get 503, got %d", code)
+	}
+	select {
+	case <-exitchan:
+		t.Fatal("Serve returned while the handler was still running")
+	case <-time.After(200 * time.Millisecond):
+	}
+	if n := server.Stats().InFlight; n != 1 {
+		t.Errorf("Expected the request to be in flight until its handler returns, got %d", n)
+	}
+
+	close(release)
+	<-returned
+	if err := <-exitchan; err != nil {
+		t.Error("Unexpected error during shutdown", err)
+	}
+	report := server.ShutdownReport()
+	if report.Clean() {
+		t.Errorf("Expected an unclean shutdown, got %+v", report)
+	}
+	if len(report.Aborted) != 1 || report.Aborted[0].Path != "/ignore" {
+		t.Errorf("Expected the cut off request to be reported as aborted, got %+v", report.Aborted)
+	}
+}
@@ -0,0 +1,63 @@
+package manners
+
+import "context"
+
+// A Tracer traces the steps of a GracefulServer's shutdown: a
+// "manners.shutdown" span covers the whole shutdown, with a child span for
+// each step and events for the signal being received, connections being
+// forcibly closed and the shutdown finishing. The otelmanners package adapts
+// an OpenTelemetry tracer to this interface.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// A Span is a traced operation started by a Tracer.
+type Span interface {
+	// AddEvent records that something happened during the span, with
+	// optional attributes.
+	AddEvent(name string, attrs map[string]int64)
+	End()
+}
+
+type nopTracer struct{}
+
+func (nopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) AddEvent(string, map[string]int64) {}
+func (nopSpan) End()                              {}
+
+func (s *GracefulServer) tracer() Tracer {
+	if s.Tracer == nil {
+		return nopTracer{}
+	}
+	return s.Tracer
+}
+
+// traceStep runs f in a span that is a child of the shutdown span. It just
+// runs f if the server is not shutting down.
+func (s *GracefulServer) traceStep(name string, f func()) {
+	s.lcsmu.RLock()
+	ctx := s.shutdownCtx
+	s.lcsmu.RUnlock()
+	if ctx == nil {
+		f()
+		return
+	}
+	_, span := s.tracer().Start(ctx, name)
+	f()
+	span.End()
+}
+
+// shutdownEvent adds an event to the shutdown span, if there is one.
+func (s *GracefulServer) shutdownEvent(name string, attrs map[string]int64) {
+	s.lcsmu.RLock()
+	span := s.shutdownSpan
+	s.lcsmu.RUnlock()
+	if span != nil {
+		span.AddEvent(name, attrs)
+	}
+}
@@ -0,0 +1,67 @@
+package manners
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// memoryTracer records the spans it starts, in the order they end.
+type memoryTracer struct {
+	sync.Mutex
+	ended []string
+}
+
+type memorySpan struct {
+	tracer *memoryTracer
+	name   string
+	events []string
+}
+
+func (mt *memoryTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, &memorySpan{tracer: mt, name: name}
+}
+
+func (ms *memorySpan) AddEvent(name string, attrs map[string]int64) {
+	ms.tracer.Lock()
+	ms.events = append(ms.events, name)
+	ms.tracer.Unlock()
+}
+
+func (ms *memorySpan) End() {
+	ms.tracer.Lock()
+	ms.tracer.ended = append(ms.tracer.ended, ms.name)
+	for _, e := range ms.events {
+		ms.tracer.ended = append(ms.tracer.ended, ms.name+": "+e)
+	}
+	ms.tracer.Unlock()
+}
+
+func TestShutdownTracing(t *testing.T) {
+	tracer := &memoryTracer{}
+	server := NewServer()
+	server.Tracer = tracer
+	_, exitchan := startServer(t, server, nil)
+
+	server.Close()
+	if err := <-exitchan; err != nil {
+		t.Error("Unexpected error during shutdown", err)
+	}
+
+	expected := []string{
+		"disable keep-alives",
+		"close idle connections",
+		"close listener",
+		"wait for routines",
+		"manners.shutdown",
+		"manners.shutdown: signal received",
+		"manners.shutdown: waiting on routines",
+		"manners.shutdown: finished",
+	}
+	tracer.Lock()
+	defer tracer.Unlock()
+	if !reflect.DeepEqual(tracer.ended, expected) {
+		t.Errorf("expected spans %v, got %v", expected, tracer.ended)
+	}
+}